@@ -0,0 +1,122 @@
+// Package auth provides authentication on top of httpok sessions: a
+// Principal stored in the session at login and exposed through the request
+// context.
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/candango/httpok/session"
+)
+
+const (
+	// ContextPrincipalValue is the context key for storing a Principal
+	// authenticated for the current request only, such as by a token.
+	ContextPrincipalValue = "HTTPOKAUTHPRINCIPALCTXVALUE"
+	// SessionPrincipalKey is the reserved session data key holding the
+	// logged-in Principal.
+	SessionPrincipalKey = "_httpok_principal"
+	// SessionAuthTimeKey is the reserved session data key holding the login
+	// time as Unix seconds.
+	SessionAuthTimeKey = "_httpok_auth_time"
+)
+
+// ErrNoPrincipal is returned by Login for a principal without an ID.
+var ErrNoPrincipal = errors.New("auth: principal has no ID")
+
+// Principal is an authenticated identity.
+type Principal struct {
+	// ID uniquely identifies the principal.
+	ID string `json:"id"`
+	// Name is a display name.
+	Name string `json:"name,omitempty"`
+	// Roles lists the roles granted to the principal.
+	Roles []string `json:"roles,omitempty"`
+	// Permissions lists the permissions granted to the principal directly.
+	Permissions []string `json:"permissions,omitempty"`
+	// Attributes carries application-defined values.
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// WithPrincipal returns a copy of ctx carrying p. Authentication that is not
+// persisted in the session, such as an API token, uses it to expose the
+// principal through CurrentUser.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, ContextPrincipalValue, p)
+}
+
+// CurrentUser returns the principal of the request: the one stored with
+// WithPrincipal or, failing that, the one logged in to the session from
+// session.SessionFromContext.
+func CurrentUser(ctx context.Context) (*Principal, bool) {
+	if p, ok := ctx.Value(ContextPrincipalValue).(*Principal); ok && p != nil {
+		return p, true
+	}
+	sess, err := session.SessionFromContext(ctx)
+	if err != nil {
+		return nil, false
+	}
+	p, err := session.GetAs[Principal](sess, SessionPrincipalKey)
+	if err != nil || p.ID == "" {
+		return nil, false
+	}
+	return &p, true
+}
+
+// AuthTime returns when the session principal logged in.
+func AuthTime(ctx context.Context) (time.Time, bool) {
+	sess, err := session.SessionFromContext(ctx)
+	if err != nil {
+		return time.Time{}, false
+	}
+	seconds, err := session.GetAs[int64](sess, SessionAuthTimeKey)
+	if err != nil || seconds == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(seconds, 0), true
+}
+
+// Login regenerates the session ID, preventing session fixation, and stores
+// p and the current time in the session from ctx. The session is persisted
+// under the new ID by Sessioned when the handler returns.
+func Login(ctx context.Context, p Principal) error {
+	if p.ID == "" {
+		return ErrNoPrincipal
+	}
+	sess, err := session.SessionFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	if err := sess.Regenerate(); err != nil {
+		return err
+	}
+	if err := sess.Set(SessionPrincipalKey, p); err != nil {
+		return err
+	}
+	return sess.Set(SessionAuthTimeKey, time.Now().Unix())
+}
+
+// Logout ends the login by destroying the session from ctx, so the server
+// side data is deleted and the next request starts a new session.
+func Logout(ctx context.Context) error {
+	sess, err := session.SessionFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	return sess.Destroy()
+}
+
+// SafeNext returns next when it is a local path, such as the "next"
+// parameter added by RequireAuth, and "/" otherwise, so login handlers can
+// redirect back without becoming open redirects.
+func SafeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") ||
+		strings.HasPrefix(next, "/\\") ||
+		strings.ContainsAny(next, "\r\n\t") {
+		return "/"
+	}
+	return next
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/candango/httpok/session"
+	"github.com/stretchr/testify/assert"
+)
+
+func sessionContext() (context.Context, *session.Session) {
+	ctx := context.WithValue(context.Background(), session.ContextEngValue,
+		session.NewStoreEngine(session.NewMemoryStore()))
+	sess := &session.Session{Id: "id", Ctx: ctx, Data: map[string]any{}}
+	return context.WithValue(ctx, session.ContextSessValue, sess), sess
+}
+
+func TestLoginLogout(t *testing.T) {
+	ctx, sess := sessionContext()
+	_, ok := CurrentUser(ctx)
+	assert.False(t, ok)
+
+	assert.ErrorIs(t, Login(ctx, Principal{}), ErrNoPrincipal)
+	before := time.Now().Unix()
+	assert.NoError(t, Login(ctx, Principal{ID: "42", Name: "Ada"}))
+	assert.True(t, sess.Changed)
+	assert.NotEqual(t, "id", sess.Id, "login regenerates the session ID")
+
+	p, ok := CurrentUser(ctx)
+	if assert.True(t, ok) {
+		assert.Equal(t, "42", p.ID)
+		assert.Equal(t, "Ada", p.Name)
+	}
+	at, ok := AuthTime(ctx)
+	assert.True(t, ok)
+	assert.GreaterOrEqual(t, at.Unix(), before)
+
+	assert.NoError(t, Logout(ctx))
+	assert.True(t, sess.Destroyed)
+	_, ok = CurrentUser(ctx)
+	assert.False(t, ok)
+}
+
+func TestCurrentUserFromStoredSession(t *testing.T) {
+	ctx, sess := sessionContext()
+	assert.NoError(t, Login(ctx, Principal{ID: "42",
+		Attributes: map[string]string{"tenant": "acme"}}))
+
+	// Emulate a session loaded back from the store by the JSON encoder.
+	data, err := json.Marshal(sess.Data)
+	assert.NoError(t, err)
+	sess.Data = map[string]any{}
+	assert.NoError(t, json.Unmarshal(data, &sess.Data))
+
+	p, ok := CurrentUser(ctx)
+	if assert.True(t, ok) {
+		assert.Equal(t, "42", p.ID)
+		assert.Equal(t, "acme", p.Attributes["tenant"])
+	}
+	_, ok = AuthTime(ctx)
+	assert.True(t, ok)
+}
+
+func TestWithPrincipal(t *testing.T) {
+	ctx := WithPrincipal(context.Background(), &Principal{ID: "token"})
+	p, ok := CurrentUser(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, "token", p.ID)
+	assert.Error(t, Login(ctx, Principal{ID: "x"}), "no session in context")
+}
+
+func TestSafeNext(t *testing.T) {
+	for next, want := range map[string]string{
+		"/account?tab=1":         "/account?tab=1",
+		"":                       "/",
+		"account":                "/",
+		"//evil.test/":           "/",
+		"/\\evil.test/":          "/",
+		"https://evil.test/":     "/",
+		"javascript:alert(1)":    "/",
+		"/a\r\nLocation: x":      "/",
+		"/redirect?to=//example": "/redirect?to=//example",
+	} {
+		assert.Equal(t, want, SafeNext(next), next)
+	}
+}
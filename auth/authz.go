@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"context"
+	"slices"
+)
+
+// Grants holds the roles and permissions a principal is checked against.
+type Grants struct {
+	Roles       []string
+	Permissions []string
+}
+
+// HasRole reports whether g includes role.
+func (g Grants) HasRole(role string) bool {
+	return slices.Contains(g.Roles, role)
+}
+
+// HasPermission reports whether g includes permission.
+func (g Grants) HasPermission(permission string) bool {
+	return slices.Contains(g.Permissions, permission)
+}
+
+// GrantProvider resolves the grants of a principal, for example from an
+// application's own permission store.
+type GrantProvider interface {
+	Grants(ctx context.Context, p *Principal) (Grants, error)
+}
+
+// GrantProviderFunc adapts a function to the GrantProvider interface.
+type GrantProviderFunc func(ctx context.Context, p *Principal) (Grants, error)
+
+// Grants calls f(ctx, p).
+func (f GrantProviderFunc) Grants(ctx context.Context,
+	p *Principal) (Grants, error) {
+	return f(ctx, p)
+}
+
+// PrincipalGrants is the GrantProvider returning the roles and permissions
+// stored in the principal itself.
+var PrincipalGrants GrantProvider = GrantProviderFunc(
+	func(_ context.Context, p *Principal) (Grants, error) {
+		return Grants{Roles: p.Roles, Permissions: p.Permissions}, nil
+	})
+
+// Policy decides whether a principal with the given grants is authorized.
+type Policy interface {
+	Allow(ctx context.Context, p *Principal, g Grants) (bool, error)
+}
+
+// PolicyFunc adapts a function to the Policy interface.
+type PolicyFunc func(ctx context.Context, p *Principal, g Grants) (bool, error)
+
+// Allow calls f(ctx, p, g).
+func (f PolicyFunc) Allow(ctx context.Context, p *Principal,
+	g Grants) (bool, error) {
+	return f(ctx, p, g)
+}
+
+// HasRole returns a Policy allowing principals with any of roles.
+func HasRole(roles ...string) Policy {
+	return PolicyFunc(func(_ context.Context, _ *Principal,
+		g Grants) (bool, error) {
+		return slices.ContainsFunc(roles, g.HasRole), nil
+	})
+}
+
+// HasPermission returns a Policy allowing principals with all of
+// permissions.
+func HasPermission(permissions ...string) Policy {
+	return PolicyFunc(func(_ context.Context, _ *Principal,
+		g Grants) (bool, error) {
+		for _, permission := range permissions {
+			if !g.HasPermission(permission) {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+}
+
+// All returns a Policy allowing principals allowed by every one of
+// policies. It stops at the first denial or error.
+func All(policies ...Policy) Policy {
+	return PolicyFunc(func(ctx context.Context, p *Principal,
+		g Grants) (bool, error) {
+		for _, policy := range policies {
+			allowed, err := policy.Allow(ctx, p, g)
+			if err != nil || !allowed {
+				return false, err
+			}
+		}
+		return true, nil
+	})
+}
+
+// Any returns a Policy allowing principals allowed by at least one of
+// policies. It stops at the first allowance or error.
+func Any(policies ...Policy) Policy {
+	return PolicyFunc(func(ctx context.Context, p *Principal,
+		g Grants) (bool, error) {
+		for _, policy := range policies {
+			allowed, err := policy.Allow(ctx, p, g)
+			if err != nil || allowed {
+				return allowed, err
+			}
+		}
+		return false, nil
+	})
+}
@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicies(t *testing.T) {
+	ctx := context.Background()
+	p := &Principal{ID: "42"}
+	g := Grants{
+		Roles:       []string{"editor"},
+		Permissions: []string{"posts:read", "posts:write"},
+	}
+	failing := PolicyFunc(func(context.Context, *Principal,
+		Grants) (bool, error) {
+		return false, errors.New("store down")
+	})
+
+	tests := []struct {
+		name    string
+		policy  Policy
+		allowed bool
+		err     bool
+	}{
+		{"role", HasRole("admin", "editor"), true, false},
+		{"missing role", HasRole("admin"), false, false},
+		{"permissions", HasPermission("posts:read", "posts:write"), true, false},
+		{"missing permission", HasPermission("posts:read", "posts:delete"),
+			false, false},
+		{"all", All(HasRole("editor"), HasPermission("posts:read")), true,
+			false},
+		{"all denied", All(HasRole("editor"), HasRole("admin")), false, false},
+		{"empty all", All(), true, false},
+		{"any", Any(HasRole("admin"), HasPermission("posts:write")), true,
+			false},
+		{"any denied", Any(HasRole("admin")), false, false},
+		{"any error", Any(HasRole("admin"), failing), false, true},
+		{"all error", All(HasRole("editor"), failing), false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed, err := tt.policy.Allow(ctx, p, g)
+			assert.Equal(t, tt.allowed, allowed)
+			assert.Equal(t, tt.err, err != nil)
+		})
+	}
+}
+
+func TestPrincipalGrants(t *testing.T) {
+	g, err := PrincipalGrants.Grants(context.Background(), &Principal{
+		ID: "42", Roles: []string{"admin"}, Permissions: []string{"x"},
+	})
+	assert.NoError(t, err)
+	assert.True(t, g.HasRole("admin"))
+	assert.True(t, g.HasPermission("x"))
+	assert.False(t, g.HasPermission("y"))
+}
@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"strings"
+)
+
+// CertificateMapper maps a verified client certificate to a principal. It
+// returns ErrInvalidCredentials for certificates it does not accept; any
+// other error is treated as a failure to map them.
+type CertificateMapper interface {
+	MapCertificate(ctx context.Context, cert *x509.Certificate) (*Principal,
+		error)
+}
+
+// CertificateMapperFunc adapts a function to the CertificateMapper
+// interface.
+type CertificateMapperFunc func(ctx context.Context,
+	cert *x509.Certificate) (*Principal, error)
+
+// MapCertificate calls f(ctx, cert).
+func (f CertificateMapperFunc) MapCertificate(ctx context.Context,
+	cert *x509.Certificate) (*Principal, error) {
+	return f(ctx, cert)
+}
+
+// MapCommonName is a CertificateMapper using the subject common name as the
+// principal ID.
+var MapCommonName CertificateMapper = CertificateMapperFunc(
+	func(_ context.Context, cert *x509.Certificate) (*Principal, error) {
+		if cert.Subject.CommonName == "" {
+			return nil, ErrInvalidCredentials
+		}
+		return &Principal{ID: cert.Subject.CommonName,
+			Name: cert.Subject.CommonName}, nil
+	})
+
+// MapURISAN returns a CertificateMapper using the first URI subject
+// alternative name starting with prefix as the principal ID, such as a
+// SPIFFE ID with the prefix "spiffe://example.org/". An empty prefix
+// accepts any URI.
+func MapURISAN(prefix string) CertificateMapper {
+	return CertificateMapperFunc(func(_ context.Context,
+		cert *x509.Certificate) (*Principal, error) {
+		for _, uri := range cert.URIs {
+			id := uri.String()
+			if strings.HasPrefix(id, prefix) {
+				return &Principal{ID: id}, nil
+			}
+		}
+		return nil, ErrInvalidCredentials
+	})
+}
+
+// FingerprintAllowlist is a CertificateMapper accepting only certificates
+// with known SHA-256 fingerprints.
+type FingerprintAllowlist struct {
+	principals map[string]Principal
+}
+
+// NewFingerprintAllowlist creates a FingerprintAllowlist mapping the
+// SHA-256 fingerprints of certificates, in hex with or without colons, to
+// their principals.
+func NewFingerprintAllowlist(
+	principals map[string]Principal) *FingerprintAllowlist {
+	a := &FingerprintAllowlist{principals: map[string]Principal{}}
+	for fingerprint, p := range principals {
+		a.principals[normalizeFingerprint(fingerprint)] = p
+	}
+	return a
+}
+
+// MapCertificate returns the principal of the fingerprint of cert.
+func (a *FingerprintAllowlist) MapCertificate(_ context.Context,
+	cert *x509.Certificate) (*Principal, error) {
+	p, ok := a.principals[CertificateFingerprint(cert)]
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	return &p, nil
+}
+
+// CertificateFingerprint returns the SHA-256 fingerprint of cert in lower
+// case hex without separators.
+func CertificateFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeFingerprint removes colons and lowercases fingerprint.
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCertificateMappers(t *testing.T) {
+	ctx := context.Background()
+	spiffe, _ := url.Parse("spiffe://example.org/sa/billing")
+	other, _ := url.Parse("https://example.com/id")
+	cert := &x509.Certificate{
+		Raw:     []byte("certificate"),
+		Subject: pkix.Name{CommonName: "billing"},
+		URIs:    []*url.URL{other, spiffe},
+	}
+
+	p, err := MapCommonName.MapCertificate(ctx, cert)
+	assert.NoError(t, err)
+	assert.Equal(t, "billing", p.ID)
+	_, err = MapCommonName.MapCertificate(ctx, &x509.Certificate{})
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	p, err = MapURISAN("spiffe://example.org/").MapCertificate(ctx, cert)
+	assert.NoError(t, err)
+	assert.Equal(t, "spiffe://example.org/sa/billing", p.ID)
+	_, err = MapURISAN("spiffe://other.org/").MapCertificate(ctx, cert)
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	fingerprint := CertificateFingerprint(cert)
+	var colons []string
+	for i := 0; i < len(fingerprint); i += 2 {
+		colons = append(colons, strings.ToUpper(fingerprint[i:i+2]))
+	}
+	allowlist := NewFingerprintAllowlist(map[string]Principal{
+		strings.Join(colons, ":"): {ID: "billing"},
+	})
+	p, err = allowlist.MapCertificate(ctx, cert)
+	assert.NoError(t, err)
+	assert.Equal(t, "billing", p.ID)
+	_, err = allowlist.MapCertificate(ctx, &x509.Certificate{Raw: []byte("x")})
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+}
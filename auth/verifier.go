@@ -0,0 +1,283 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrInvalidCredentials is returned by verifiers rejecting the credentials
+// of a request.
+var ErrInvalidCredentials = errors.New("auth: invalid credentials")
+
+// BasicVerifier checks the user name and password of HTTP Basic
+// authentication. It returns ErrInvalidCredentials for wrong credentials;
+// any other error is treated as a failure to check them.
+type BasicVerifier interface {
+	VerifyBasic(ctx context.Context, username, password string) (*Principal,
+		error)
+}
+
+// BasicVerifierFunc adapts a function to the BasicVerifier interface.
+type BasicVerifierFunc func(ctx context.Context, username,
+	password string) (*Principal, error)
+
+// VerifyBasic calls f(ctx, username, password).
+func (f BasicVerifierFunc) VerifyBasic(ctx context.Context, username,
+	password string) (*Principal, error) {
+	return f(ctx, username, password)
+}
+
+// TokenVerifier checks a bearer token. It returns ErrInvalidCredentials for
+// unknown or expired tokens; any other error is treated as a failure to
+// check them.
+type TokenVerifier interface {
+	VerifyToken(ctx context.Context, token string) (*Principal, error)
+}
+
+// TokenVerifierFunc adapts a function to the TokenVerifier interface.
+type TokenVerifierFunc func(ctx context.Context, token string) (*Principal,
+	error)
+
+// VerifyToken calls f(ctx, token).
+func (f TokenVerifierFunc) VerifyToken(ctx context.Context,
+	token string) (*Principal, error) {
+	return f(ctx, token)
+}
+
+// StaticTokens is a TokenVerifier for a fixed set of tokens, mapping each
+// token to its principal.
+type StaticTokens struct {
+	tokens []staticToken
+}
+
+type staticToken struct {
+	digest    [sha256.Size]byte
+	principal Principal
+}
+
+// NewStaticTokens creates a StaticTokens accepting the keys of tokens.
+func NewStaticTokens(tokens map[string]Principal) *StaticTokens {
+	s := &StaticTokens{}
+	for token, p := range tokens {
+		s.tokens = append(s.tokens, staticToken{
+			digest:    sha256.Sum256([]byte(token)),
+			principal: p,
+		})
+	}
+	return s
+}
+
+// VerifyToken compares token against every known token in constant time,
+// so neither the match position nor the token length leaks through timing.
+func (s *StaticTokens) VerifyToken(_ context.Context,
+	token string) (*Principal, error) {
+	digest := sha256.Sum256([]byte(token))
+	found := -1
+	for i := range s.tokens {
+		if subtle.ConstantTimeCompare(digest[:], s.tokens[i].digest[:]) == 1 {
+			found = i
+		}
+	}
+	if found < 0 {
+		return nil, ErrInvalidCredentials
+	}
+	p := s.tokens[found].principal
+	return &p, nil
+}
+
+// Htpasswd is a BasicVerifier for users in an Apache htpasswd file. It
+// supports bcrypt ("$2y$", as written by htpasswd -B, and "$2a$" or "$2b$"),
+// APR1 MD5 ("$apr1$") and SHA-1 ("{SHA}") entries, and plain text passwords
+// when HtpasswdOptions.AllowPlain is set. Prefer bcrypt: APR1 and SHA-1 are
+// fast to brute force. Other entries, such as crypt(3), are rejected when
+// the file is parsed.
+type Htpasswd struct {
+	users map[string]string
+	// dummy is checked for unknown users, as costly as the known entries.
+	dummy string
+}
+
+// HtpasswdOptions configures htpasswd parsing.
+type HtpasswdOptions struct {
+	// AllowPlain accepts entries without a hash prefix as plain text
+	// passwords. Without it they are rejected, since a crypt(3) DES hash
+	// has no prefix either and would be accepted as its own password.
+	AllowPlain bool
+}
+
+// LoadHtpasswd reads an htpasswd file. A nil opts uses the defaults.
+func LoadHtpasswd(name string, opts *HtpasswdOptions) (*Htpasswd, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseHtpasswd(file, opts)
+}
+
+// ParseHtpasswd reads htpasswd entries, one "user:hash" per line. Blank
+// lines and lines starting with "#" are ignored. A nil opts uses the
+// defaults.
+func ParseHtpasswd(r io.Reader, opts *HtpasswdOptions) (*Htpasswd, error) {
+	options := HtpasswdOptions{}
+	if opts != nil {
+		options = *opts
+	}
+	h := &Htpasswd{users: map[string]string{}, dummy: "$apr1$00000000$"}
+	dummyCost := 0
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(text, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("htpasswd: malformed entry on line %d",
+				line)
+		}
+		supported := strings.HasPrefix(hash, "$apr1$") ||
+			strings.HasPrefix(hash, "{SHA}") ||
+			options.AllowPlain && !strings.HasPrefix(hash, "$")
+		if isBcrypt(hash) {
+			cost, err := bcrypt.Cost([]byte(hash))
+			if err != nil {
+				return nil, fmt.Errorf(
+					"htpasswd: invalid bcrypt hash for user %q on line %d: %w",
+					user, line, err)
+			}
+			dummyCost = max(dummyCost, cost)
+			supported = true
+		}
+		if !supported {
+			return nil, fmt.Errorf(
+				"htpasswd: unsupported hash for user %q on line %d", user, line)
+		}
+		h.users[user] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if dummyCost > 0 {
+		dummy, err := bcrypt.GenerateFromPassword(nil, dummyCost)
+		if err != nil {
+			return nil, err
+		}
+		h.dummy = string(dummy)
+	}
+	return h, nil
+}
+
+// VerifyBasic checks password against the entry of username. The principal
+// has username as its ID and name.
+func (h *Htpasswd) VerifyBasic(_ context.Context, username,
+	password string) (*Principal, error) {
+	hash, ok := h.users[username]
+	if !ok {
+		// Spend the same work on unknown users as on known ones.
+		hash = h.dummy
+	}
+	if !htpasswdMatch(hash, password) || !ok {
+		return nil, ErrInvalidCredentials
+	}
+	return &Principal{ID: username, Name: username}, nil
+}
+
+// htpasswdMatch compares password with an htpasswd hash in constant time.
+func htpasswdMatch(hash, password string) bool {
+	var computed string
+	switch {
+	case isBcrypt(hash):
+		return bcrypt.CompareHashAndPassword([]byte(hash),
+			[]byte(password)) == nil
+	case strings.HasPrefix(hash, "$apr1$"):
+		salt, _, _ := strings.Cut(hash[len("$apr1$"):], "$")
+		computed = apr1(password, salt)
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		computed = "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+	default:
+		computed = password
+	}
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(computed)) == 1
+}
+
+// isBcrypt reports whether hash is a bcrypt hash.
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2y$") || strings.HasPrefix(hash, "$2a$") ||
+		strings.HasPrefix(hash, "$2b$")
+}
+
+// apr1 returns the Apache MD5 crypt hash of password with salt, in the
+// "$apr1$salt$digest" form.
+func apr1(password, salt string) string {
+	const magic = "$apr1$"
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw := []byte(password)
+
+	alternate := md5.Sum([]byte(password + salt + password))
+	h := md5.New()
+	h.Write([]byte(password + magic + salt))
+	for i := len(pw); i > 0; i -= md5.Size {
+		h.Write(alternate[:min(i, md5.Size)])
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			h.Write([]byte{0})
+		} else {
+			h.Write(pw[:1])
+		}
+	}
+	final := h.Sum(nil)
+
+	for i := range 1000 {
+		h := md5.New()
+		if i&1 != 0 {
+			h.Write(pw)
+		} else {
+			h.Write(final)
+		}
+		if i%3 != 0 {
+			h.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			h.Write(pw)
+		}
+		if i&1 != 0 {
+			h.Write(final)
+		} else {
+			h.Write(pw)
+		}
+		final = h.Sum(nil)
+	}
+
+	const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	var out strings.Builder
+	encode := func(v uint, n int) {
+		for ; n > 0; n-- {
+			out.WriteByte(itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	for _, g := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14},
+		{3, 9, 15}, {4, 10, 5}} {
+		encode(uint(final[g[0]])<<16|uint(final[g[1]])<<8|uint(final[g[2]]),
+			4)
+	}
+	encode(uint(final[11]), 2)
+	return magic + salt + "$" + out.String()
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApr1(t *testing.T) {
+	// Expected values from `openssl passwd -apr1 -salt <salt> <password>`.
+	assert.Equal(t, "$apr1$saltsalt$11QAx5Oiaores.cTy1Ple.",
+		apr1("p@ss word", "saltsalt"))
+	assert.Equal(t, "$apr1$ab$eIePjsejfBGR8ITtu2z0U1", apr1("x", "ab"))
+	assert.Equal(t, "$apr1$12345678$IYuWL9wFFxgFexn67r6Ix0",
+		apr1("a-very-long-password-longer-than-sixteen", "12345678"))
+}
+
+func TestHtpasswd(t *testing.T) {
+	name := filepath.Join(t.TempDir(), ".htpasswd")
+	assert.NoError(t, os.WriteFile(name, []byte(strings.Join([]string{
+		"# admins",
+		"alice:$apr1$saltsalt$11QAx5Oiaores.cTy1Ple.",
+		"bob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=",
+		"erin:$2y$05$xeQduMDtLVMvbJBvKUlGKOIxvsvaCEduWukbFCcYA/8a33T02m8py",
+		"",
+		"carol:plain",
+	}, "\n")), 0600))
+	_, err := LoadHtpasswd(name, nil)
+	assert.ErrorContains(t, err, "line 6", "plain text needs AllowPlain")
+	h, err := LoadHtpasswd(name, &HtpasswdOptions{AllowPlain: true})
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	for _, tt := range []struct {
+		user, password string
+		ok             bool
+	}{
+		{"alice", "p@ss word", true},
+		{"alice", "p@ss", false},
+		{"bob", "secret", true},
+		{"bob", "Secret", false},
+		{"erin", "s3cret pass", true},
+		{"erin", "s3cret", false},
+		{"carol", "plain", true},
+		{"carol", "plai", false},
+		{"dave", "", false},
+	} {
+		p, err := h.VerifyBasic(ctx, tt.user, tt.password)
+		if tt.ok {
+			assert.NoError(t, err, tt.user)
+			assert.Equal(t, tt.user, p.ID)
+			continue
+		}
+		assert.ErrorIs(t, err, ErrInvalidCredentials, tt.user)
+	}
+
+	_, err = ParseHtpasswd(strings.NewReader("erin:$2y$05$short"), nil)
+	assert.ErrorContains(t, err, "invalid bcrypt hash")
+	h, err = ParseHtpasswd(strings.NewReader(
+		"erin:$2y$05$xeQduMDtLVMvbJBvKUlGKOIxvsvaCEduWukbFCcYA/8a33T02m8py"), nil)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(h.dummy, "$2a$05$"),
+		"unknown users cost as much as bcrypt entries")
+	_, err = ParseHtpasswd(strings.NewReader("frank:rl.3StKT.4T8M"), nil)
+	assert.ErrorContains(t, err, "unsupported hash", "crypt(3) DES entry")
+	_, err = ParseHtpasswd(strings.NewReader("no-separator"), nil)
+	assert.ErrorContains(t, err, "line 1")
+}
+
+func TestStaticTokens(t *testing.T) {
+	tokens := NewStaticTokens(map[string]Principal{
+		"token-a": {ID: "ci"},
+		"token-b": {ID: "deploy", Roles: []string{"admin"}},
+	})
+	p, err := tokens.VerifyToken(context.Background(), "token-b")
+	assert.NoError(t, err)
+	assert.Equal(t, "deploy", p.ID)
+	assert.Equal(t, []string{"admin"}, p.Roles)
+
+	_, err = tokens.VerifyToken(context.Background(), "token-")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+}
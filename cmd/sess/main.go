@@ -65,22 +65,12 @@ func main() {
 			log.Printf("error: %v", err)
 			return
 		}
-		count := 0
-		ok, err := sess.Has("count")
+		count, err := session.GetAs[int](sess, "count")
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			log.Printf("error: %v", err)
 			return
 		}
-		if ok {
-			cs, err := sess.Get("count")
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				log.Printf("error: %v", err)
-				return
-			}
-			count = int(cs.(float64))
-		}
 		log.Printf("%v", sess.Data)
 
 		log.Printf("Request %d received from %s/n", reqCount, r.URL.Path)
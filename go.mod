@@ -1,12 +1,13 @@
 module github.com/candango/httpok
 
-go 1.25
+go 1.25.0
 
 require (
 	github.com/candango/iook v0.1.0
 	github.com/candango/schedulerok v0.2.0
 	github.com/go-kit/log v0.2.1
 	github.com/stretchr/testify v1.12.1
+	golang.org/x/crypto v0.54.0
 )
 
 require (
@@ -41,6 +41,9 @@ func BodyAsJson(res *http.Response, jsonBody any) error {
 type WrappedWriter struct {
 	http.ResponseWriter
 	StatusCode int
+	// HeaderWritten reports whether the response headers were already sent,
+	// either explicitly by WriteHeader or implicitly by the first Write.
+	HeaderWritten bool
 }
 
 // WriteHeader records the status code and then calls the underlying
@@ -50,4 +53,27 @@ type WrappedWriter struct {
 func (w *WrappedWriter) WriteHeader(c int) {
 	w.ResponseWriter.WriteHeader(c)
 	w.StatusCode = c
+	w.HeaderWritten = true
+}
+
+// Write marks the headers as written and then calls the underlying Write
+// method.
+func (w *WrappedWriter) Write(b []byte) (int, error) {
+	w.HeaderWritten = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends any buffered data to the client when the underlying
+// ResponseWriter supports it.
+func (w *WrappedWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.HeaderWritten = true
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter, allowing
+// http.ResponseController to reach optional interfaces it implements.
+func (w *WrappedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
 }
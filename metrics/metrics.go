@@ -0,0 +1,232 @@
+// Package metrics provides counters, gauges and histograms exposed in the
+// Prometheus text exposition format without external dependencies.
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the histogram upper bounds, in seconds, used when no
+// buckets are given. They suit HTTP request and storage latencies.
+var DefaultBuckets = []float64{
+	.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10,
+}
+
+const (
+	counterType   = "counter"
+	gaugeType     = "gauge"
+	histogramType = "histogram"
+	// labelSeparator joins label values into series keys. It cannot appear
+	// in valid UTF-8 text.
+	labelSeparator = "\xff"
+)
+
+// Registry holds metric families and renders them in the text exposition
+// format.
+type Registry struct {
+	families map[string]*family
+	mu       sync.Mutex
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{families: map[string]*family{}}
+}
+
+// family is a named metric with a fixed set of label names. Each combination
+// of label values is a series.
+type family struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	series  map[string]*series
+	mu      sync.Mutex
+}
+
+// series holds the values of one label value combination.
+type series struct {
+	labelValues []string
+	value       float64
+	counts      []uint64
+	sum         float64
+	count       uint64
+}
+
+// register returns the family named name, creating it when needed. It panics
+// when name or labels are invalid or when an existing family with the same
+// name has a different type or label set, as those are programming errors.
+func (r *Registry) register(name, help, kind string, buckets []float64,
+	labels []string) *family {
+	if !validMetricName(name) {
+		panic(fmt.Sprintf("metrics: invalid metric name %q", name))
+	}
+	for _, label := range labels {
+		if !validLabelName(label) || (kind == histogramType && label == "le") {
+			panic(fmt.Sprintf("metrics: invalid label name %q for %s", label,
+				name))
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f, ok := r.families[name]; ok {
+		if f.kind != kind || strings.Join(f.labels, ",") !=
+			strings.Join(labels, ",") {
+			panic(fmt.Sprintf("metrics: %s already registered with a "+
+				"different type or labels", name))
+		}
+		return f
+	}
+	f := &family{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  append([]string(nil), labels...),
+		buckets: buckets,
+		series:  map[string]*series{},
+	}
+	r.families[name] = f
+	return f
+}
+
+// with runs fn with the series matching labelValues while holding the
+// family lock.
+func (f *family) with(labelValues []string, fn func(*series)) {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d",
+			f.name, len(f.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, labelSeparator)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		if f.kind == histogramType {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	fn(s)
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	f *family
+}
+
+// NewCounter returns the counter named name, registering it on first use.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{f: r.register(name, help, counterType, nil, labels)}
+}
+
+// Inc increments the counter for labelValues by one.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increments the counter for labelValues by v. Negative values are
+// ignored because counters never decrease.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		return
+	}
+	c.f.with(labelValues, func(s *series) { s.value += v })
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	f *family
+}
+
+// NewGauge returns the gauge named name, registering it on first use.
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{f: r.register(name, help, gaugeType, nil, labels)}
+}
+
+// Set sets the gauge for labelValues to v.
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.f.with(labelValues, func(s *series) { s.value = v })
+}
+
+// Add adds v, which may be negative, to the gauge for labelValues.
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.f.with(labelValues, func(s *series) { s.value += v })
+}
+
+// Inc increments the gauge for labelValues by one.
+func (g *Gauge) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+// Dec decrements the gauge for labelValues by one.
+func (g *Gauge) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+// Histogram counts observations into cumulative buckets.
+type Histogram struct {
+	f *family
+}
+
+// NewHistogram returns the histogram named name, registering it on first
+// use. A nil buckets slice uses DefaultBuckets. Buckets are sorted and the
+// +Inf bucket is implicit.
+func (r *Registry) NewHistogram(name, help string, buckets []float64,
+	labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	sorted := make([]float64, 0, len(buckets))
+	for _, bucket := range buckets {
+		if !math.IsInf(bucket, 1) && !math.IsNaN(bucket) {
+			sorted = append(sorted, bucket)
+		}
+	}
+	sort.Float64s(sorted)
+	return &Histogram{f: r.register(name, help, histogramType, sorted, labels)}
+}
+
+// Observe records v for labelValues.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.f.with(labelValues, func(s *series) {
+		for i, bound := range h.f.buckets {
+			if v <= bound {
+				s.counts[i]++
+			}
+		}
+		s.sum += v
+		s.count++
+	})
+}
+
+func validMetricName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, char := range name {
+		if (char < 'a' || char > 'z') && (char < 'A' || char > 'Z') &&
+			char != '_' && char != ':' && (i == 0 || char < '0' || char > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+func validLabelName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "__") {
+		return false
+	}
+	for i, char := range name {
+		if (char < 'a' || char > 'z') && (char < 'A' || char > 'Z') &&
+			char != '_' && (i == 0 || char < '0' || char > '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistryWriteText(t *testing.T) {
+	registry := NewRegistry()
+	requests := registry.NewCounter("app_requests_total", "Requests.\nServed",
+		"code")
+	requests.Inc("200")
+	requests.Add(2, "200")
+	requests.Inc(`a"b\c`)
+	requests.Add(-1, "200")
+
+	temperature := registry.NewGauge("app_temperature", "")
+	temperature.Set(20.5)
+	temperature.Dec()
+
+	latency := registry.NewHistogram("app_latency_seconds", "Latency.",
+		[]float64{1, 0.1})
+	latency.Observe(0.05)
+	latency.Observe(0.5)
+	latency.Observe(5)
+
+	var out strings.Builder
+	assert.NoError(t, registry.WriteText(&out))
+	assert.Equal(t, `# HELP app_latency_seconds Latency.
+# TYPE app_latency_seconds histogram
+app_latency_seconds_bucket{le="0.1"} 1
+app_latency_seconds_bucket{le="1"} 2
+app_latency_seconds_bucket{le="+Inf"} 3
+app_latency_seconds_sum 5.55
+app_latency_seconds_count 3
+# HELP app_requests_total Requests.\nServed
+# TYPE app_requests_total counter
+app_requests_total{code="200"} 3
+app_requests_total{code="a\"b\\c"} 1
+# TYPE app_temperature gauge
+app_temperature 19.5
+`, out.String())
+}
+
+func TestRegistryReusesAndRejectsFamilies(t *testing.T) {
+	registry := NewRegistry()
+	first := registry.NewCounter("reused_total", "help", "a")
+	second := registry.NewCounter("reused_total", "help", "a")
+	first.Inc("x")
+	second.Inc("x")
+
+	var out strings.Builder
+	assert.NoError(t, registry.WriteText(&out))
+	assert.Contains(t, out.String(), `reused_total{a="x"} 2`)
+
+	assert.Panics(t, func() { registry.NewGauge("reused_total", "help", "a") })
+	assert.Panics(t, func() { registry.NewCounter("reused_total", "help", "b") })
+	assert.Panics(t, func() { registry.NewCounter("0invalid", "help") })
+	assert.Panics(t, func() { registry.NewHistogram("h", "help", nil, "le") })
+	assert.Panics(t, func() { first.Inc() })
+}
+
+func TestHandler(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewCounter("served_total", "Served.").Inc()
+
+	response := httptest.NewRecorder()
+	Handler(registry).ServeHTTP(response,
+		httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(t, ContentType, response.Header().Get("Content-Type"))
+	body, _ := io.ReadAll(response.Body)
+	assert.Contains(t, string(body), "served_total 1\n")
+}
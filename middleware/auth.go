@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/candango/httpok/auth"
+)
+
+// ErrUnauthenticated is passed to the ErrorRenderer for API requests
+// rejected by RequireAuth.
+var ErrUnauthenticated = errors.New("authentication required")
+
+// RequireAuthOptions configures the RequireAuth middleware.
+type RequireAuthOptions struct {
+	// LoginURL is where browsers are redirected. Empty uses "/login".
+	LoginURL string
+	// NextParam is the query parameter carrying the original request URI.
+	// Empty uses "next".
+	NextParam string
+	// IsAPI reports whether r comes from an API client, which gets an error
+	// instead of a redirect. Nil treats GET and HEAD requests accepting
+	// text/html as browser requests and everything else as API requests.
+	IsAPI func(r *http.Request) bool
+	// WWWAuthenticate is the challenge sent in the WWW-Authenticate header
+	// of 401 responses. Empty answers API clients with 403 instead, since a
+	// 401 must carry a challenge.
+	WWWAuthenticate string
+	// Renderer writes the 401 and 403 responses. A nil renderer uses
+	// TextErrorRenderer.
+	Renderer ErrorRenderer
+}
+
+// RequireAuth creates a middleware serving only requests with a principal,
+// as returned by auth.CurrentUser. It must run inside Sessioned to see
+// session logins.
+//
+// Browsers are redirected with 303 See Other to the login URL, with the
+// original request URI in the next parameter; login handlers should pass it
+// through auth.SafeNext before redirecting back. API clients get 401
+// Unauthorized with the WWWAuthenticate challenge, or 403 Forbidden when none
+// is configured.
+func RequireAuth(opts *RequireAuthOptions) func(http.Handler) http.Handler {
+	var options RequireAuthOptions
+	if opts != nil {
+		options = *opts
+	}
+	if options.LoginURL == "" {
+		options.LoginURL = "/login"
+	}
+	if options.NextParam == "" {
+		options.NextParam = "next"
+	}
+	if options.IsAPI == nil {
+		options.IsAPI = isAPIRequest
+	}
+	if options.Renderer == nil {
+		options.Renderer = TextErrorRenderer
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := auth.CurrentUser(r.Context()); ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if options.IsAPI(r) {
+				options.Renderer(w, r,
+					unauthenticatedStatus(w, options.WWWAuthenticate),
+					ErrUnauthenticated)
+				return
+			}
+			http.Redirect(w, r, loginRedirectURL(options.LoginURL,
+				options.NextParam, r.URL.RequestURI()), http.StatusSeeOther)
+		})
+	}
+}
+
+// unauthenticatedStatus returns the status for a request without a
+// principal: 401 Unauthorized with challenge in the WWW-Authenticate header,
+// which RFC 9110 requires on every 401, or 403 Forbidden without a challenge.
+func unauthenticatedStatus(w http.ResponseWriter, challenge string) int {
+	if challenge == "" {
+		return http.StatusForbidden
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	return http.StatusUnauthorized
+}
+
+// isAPIRequest treats everything except GET and HEAD requests accepting
+// HTML as API requests.
+func isAPIRequest(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return true
+	}
+	return !strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// loginRedirectURL adds the next parameter to loginURL, keeping any query it
+// already has.
+func loginRedirectURL(loginURL, param, next string) string {
+	u, err := url.Parse(loginURL)
+	if err != nil {
+		return loginURL
+	}
+	query := u.Query()
+	query.Set(param, next)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/candango/httpok/auth"
+	"github.com/candango/httpok/session"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequireAuth(t *testing.T) {
+	engine := session.NewStoreEngine(session.NewMemoryStore())
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /login", func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, auth.Login(r.Context(), auth.Principal{ID: "42"}))
+	})
+	mux.HandleFunc("POST /logout", func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, auth.Logout(r.Context()))
+	})
+	mux.Handle("/private", RequireAuth(&RequireAuthOptions{
+		WWWAuthenticate: `Session realm="app"`,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, _ := auth.CurrentUser(r.Context())
+		_, _ = w.Write([]byte(p.ID))
+	})))
+	handler := Sessioned(engine)(mux)
+
+	serve := func(method, target, accept string,
+		cookie *http.Cookie) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, nil)
+		if accept != "" {
+			request.Header.Set("Accept", accept)
+		}
+		if cookie != nil {
+			request.AddCookie(cookie)
+		}
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		return response
+	}
+
+	response := serve(http.MethodGet, "/private?x=1&y=2", "text/html", nil)
+	assert.Equal(t, http.StatusSeeOther, response.Code)
+	location, err := url.Parse(response.Header().Get("Location"))
+	assert.NoError(t, err)
+	assert.Equal(t, "/login", location.Path)
+	assert.Equal(t, "/private?x=1&y=2", location.Query().Get("next"))
+
+	response = serve(http.MethodGet, "/private", "application/json", nil)
+	assert.Equal(t, http.StatusUnauthorized, response.Code)
+	assert.Equal(t, `Session realm="app"`,
+		response.Header().Get("WWW-Authenticate"))
+	assert.Equal(t, http.StatusUnauthorized,
+		serve(http.MethodPost, "/private", "text/html", nil).Code)
+
+	mux.Handle("/no-challenge", RequireAuth(nil)(http.HandlerFunc(
+		func(http.ResponseWriter, *http.Request) {})))
+	response = serve(http.MethodGet, "/no-challenge", "application/json", nil)
+	assert.Equal(t, http.StatusForbidden, response.Code,
+		"a 401 needs a challenge")
+	assert.Empty(t, response.Header().Get("WWW-Authenticate"))
+
+	cookie := serve(http.MethodPost, "/login", "", nil).Result().Cookies()[0]
+	response = serve(http.MethodGet, "/private", "", cookie)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "42", response.Body.String())
+
+	serve(http.MethodPost, "/logout", "", cookie)
+	assert.Equal(t, http.StatusUnauthorized,
+		serve(http.MethodGet, "/private", "", cookie).Code)
+}
+
+func TestLoginPreventsSessionFixation(t *testing.T) {
+	store := session.NewMemoryStore()
+	engine := session.NewStoreEngine(store)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /", func(w http.ResponseWriter, r *http.Request) {
+		sess, err := session.SessionFromContext(r.Context())
+		assert.NoError(t, err)
+		assert.NoError(t, sess.Set("visited", true))
+	})
+	mux.HandleFunc("POST /login", func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, auth.Login(r.Context(), auth.Principal{ID: "42"}))
+	})
+	mux.HandleFunc("GET /me", func(w http.ResponseWriter, r *http.Request) {
+		if p, ok := auth.CurrentUser(r.Context()); ok {
+			_, _ = w.Write([]byte(p.ID))
+		}
+	})
+	handler := Sessioned(engine)(mux)
+	serve := func(method, target string,
+		cookie *http.Cookie) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, nil)
+		request.AddCookie(cookie)
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		return response
+	}
+
+	// The attacker obtains a session and plants its cookie in the victim's
+	// browser, which then logs in with it.
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/", nil))
+	planted := response.Result().Cookies()[0]
+	response = serve(http.MethodPost, "/login", planted)
+	cookies := response.Result().Cookies()
+	if !assert.Len(t, cookies, 1) {
+		return
+	}
+	assert.NotEqual(t, planted.Value, cookies[0].Value,
+		"login moves the session to a new ID")
+	exists, err := store.Exists(context.Background(), planted.Value)
+	assert.NoError(t, err)
+	assert.False(t, exists, "the planted ID is deleted")
+
+	assert.Equal(t, "42", serve(http.MethodGet, "/me", cookies[0]).Body.String())
+	assert.Empty(t, serve(http.MethodGet, "/me", planted).Body.String(),
+		"the planted cookie is not logged in")
+}
+
+func TestRequireAuthLoginURL(t *testing.T) {
+	handler := RequireAuth(&RequireAuthOptions{
+		LoginURL:  "https://sso.example.com/auth?app=1",
+		NextParam: "return_to",
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	request := httptest.NewRequest(http.MethodGet, "/a", nil)
+	request.Header.Set("Accept", "text/html,application/xhtml+xml")
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	assert.Equal(t, "https://sso.example.com/auth?app=1&return_to=%2Fa",
+		response.Header().Get("Location"))
+}
+
+func TestKeyByUser(t *testing.T) {
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	assert.Equal(t, "", KeyByUser(request))
+	request = request.WithContext(auth.WithPrincipal(request.Context(),
+		&auth.Principal{ID: "42"}))
+	assert.Equal(t, "user:42", KeyByUser(request))
+}
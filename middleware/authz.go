@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/candango/httpok/auth"
+)
+
+// ErrForbidden is passed to the ErrorRenderer for requests denied by
+// Authorize.
+var ErrForbidden = errors.New("forbidden")
+
+// AuthorizeOptions configures the Authorize middleware.
+type AuthorizeOptions struct {
+	// Grants resolves the roles and permissions of the principal. Nil uses
+	// auth.PrincipalGrants.
+	Grants auth.GrantProvider
+	// Audit is called for every denied request with the principal, nil for
+	// anonymous requests, and the error passed to the renderer.
+	Audit func(r *http.Request, p *auth.Principal, err error)
+	// WWWAuthenticate is the challenge sent in the WWW-Authenticate header
+	// of 401 responses. Empty answers requests without a principal with 403
+	// instead, since a 401 must carry a challenge.
+	WWWAuthenticate string
+	// Renderer writes the 401, 403 and 500 responses. A nil renderer uses
+	// TextErrorRenderer.
+	Renderer ErrorRenderer
+}
+
+// Authorize creates a middleware serving only requests whose principal, as
+// returned by auth.CurrentUser, is allowed by every one of policies. Use
+// auth.Any to require just one of them.
+//
+// Requests without a principal get 401 Unauthorized with the WWWAuthenticate
+// challenge, or 403 Forbidden when none is configured, and denied requests
+// get 403 Forbidden; place RequireAuth before it to redirect browsers to a login
+// page instead. Errors from the grant provider or a policy are logged and
+// answered with 500 Internal Server Error.
+func Authorize(opts *AuthorizeOptions,
+	policies ...auth.Policy) func(http.Handler) http.Handler {
+	var options AuthorizeOptions
+	if opts != nil {
+		options = *opts
+	}
+	if options.Grants == nil {
+		options.Grants = auth.PrincipalGrants
+	}
+	if options.Renderer == nil {
+		options.Renderer = TextErrorRenderer
+	}
+	policy := auth.All(policies...)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			deny := func(p *auth.Principal, status int, err error) {
+				if options.Audit != nil {
+					options.Audit(r, p, err)
+				}
+				options.Renderer(w, r, status, err)
+			}
+			p, ok := auth.CurrentUser(r.Context())
+			if !ok {
+				deny(nil, unauthenticatedStatus(w, options.WWWAuthenticate),
+					ErrUnauthenticated)
+				return
+			}
+			grants, err := options.Grants.Grants(r.Context(), p)
+			if err != nil {
+				logf(r, "authorization: failed to resolve grants of %q: %v",
+					p.ID, err)
+				deny(p, http.StatusInternalServerError, err)
+				return
+			}
+			allowed, err := policy.Allow(r.Context(), p, grants)
+			if err != nil {
+				logf(r, "authorization: policy failed for %q: %v", p.ID, err)
+				deny(p, http.StatusInternalServerError, err)
+				return
+			}
+			if !allowed {
+				deny(p, http.StatusForbidden, ErrForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
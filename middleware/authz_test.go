@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/candango/httpok/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthorize(t *testing.T) {
+	type denial struct {
+		id  string
+		err error
+	}
+	var denials []denial
+	options := &AuthorizeOptions{
+		Grants: auth.GrantProviderFunc(func(_ context.Context,
+			p *auth.Principal) (auth.Grants, error) {
+			switch p.ID {
+			case "admin":
+				return auth.Grants{Roles: []string{"admin"}}, nil
+			case "broken":
+				return auth.Grants{}, errors.New("store down")
+			}
+			return auth.Grants{Permissions: []string{"posts:read"}}, nil
+		}),
+		Audit: func(r *http.Request, p *auth.Principal, err error) {
+			id := ""
+			if p != nil {
+				id = p.ID
+			}
+			denials = append(denials, denial{id, err})
+		},
+	}
+
+	mux := http.NewServeMux()
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	posts := NewGroup(mux, Authorize(options, auth.Any(auth.HasRole("admin"),
+		auth.HasPermission("posts:read"))))
+	posts.HandleFunc("GET /posts", ok)
+	posts.With(Authorize(options, auth.HasRole("admin"))).
+		HandleFunc("DELETE /posts", ok)
+
+	serve := func(method, id string) int {
+		request := httptest.NewRequest(method, "/posts", nil)
+		if id != "" {
+			request = request.WithContext(auth.WithPrincipal(
+				request.Context(), &auth.Principal{ID: id}))
+		}
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+		return response.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "reader"))
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "admin"))
+	assert.Empty(t, denials)
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodDelete, "reader"))
+	assert.Equal(t, http.StatusOK, serve(http.MethodDelete, "admin"))
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodGet, ""),
+		"no challenge configured")
+	assert.Equal(t, http.StatusInternalServerError,
+		serve(http.MethodGet, "broken"))
+
+	if assert.Len(t, denials, 3) {
+		assert.Equal(t, denial{"reader", ErrForbidden}, denials[0])
+		assert.Equal(t, denial{"", ErrUnauthenticated}, denials[1])
+		assert.Equal(t, "broken", denials[2].id)
+	}
+}
+
+func TestAuthorizeChain(t *testing.T) {
+	var served bool
+	handler := Chain(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			served = true
+		}),
+		Authorize(nil, auth.HasPermission("reports:read")),
+	)
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request = request.WithContext(auth.WithPrincipal(request.Context(),
+		&auth.Principal{ID: "42", Permissions: []string{"reports:read"}}))
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	assert.True(t, served)
+	assert.Equal(t, http.StatusOK, response.Code)
+}
+
+func TestAuthorizeChallenge(t *testing.T) {
+	handler := Authorize(&AuthorizeOptions{
+		WWWAuthenticate: `Bearer realm="api"`,
+	}, auth.HasRole("admin"))(http.HandlerFunc(
+		func(http.ResponseWriter, *http.Request) {}))
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusUnauthorized, response.Code)
+	assert.Equal(t, `Bearer realm="api"`,
+		response.Header().Get("WWW-Authenticate"))
+}
@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/candango/httpok/auth"
+)
+
+// ErrClientCertRequired is passed to the ErrorRenderer for requests without
+// a verified client certificate.
+var ErrClientCertRequired = errors.New("verified client certificate required")
+
+// ErrIssuerNotAllowed is passed to the ErrorRenderer for client
+// certificates not issued by one of the required CAs.
+var ErrIssuerNotAllowed = errors.New("client certificate issuer not allowed")
+
+// ClientCertOptions configures the ClientCertAuth middleware.
+type ClientCertOptions struct {
+	// Issuers restricts the accepted certificates to those whose verified
+	// chain includes one of these CA certificates. Empty accepts every
+	// certificate verified by the server.
+	Issuers []*x509.Certificate
+	// Renderer writes the 403 and 500 responses. A nil renderer uses
+	// TextErrorRenderer.
+	Renderer ErrorRenderer
+}
+
+// ClientCertAuth creates a middleware authenticating requests with the TLS
+// client certificate, mapped to a principal by mapper.
+//
+// Only certificates verified during the handshake are used, so the server
+// TLSConfig must set ClientCAs and a ClientAuth of VerifyClientCertIfGiven
+// or RequireAndVerifyClientCert. Requests without a verified certificate,
+// including those reaching the server through a TLS-terminating proxy, get
+// 403 Forbidden: HTTP has no authentication scheme to challenge for a
+// certificate, which can only be sent in a new TLS handshake. Certificates
+// from issuers not in Issuers, or rejected by mapper, get 403 Forbidden as
+// well. Mapper errors other than
+// auth.ErrInvalidCredentials are logged and answered with 500 Internal
+// Server Error. The principal is stored with auth.WithPrincipal.
+//
+// Issuers can differ per route, so one server can accept several CAs while
+// each route only trusts its own.
+func ClientCertAuth(mapper auth.CertificateMapper,
+	opts *ClientCertOptions) func(http.Handler) http.Handler {
+	var options ClientCertOptions
+	if opts != nil {
+		options = *opts
+	}
+	if options.Renderer == nil {
+		options.Renderer = TextErrorRenderer
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+				options.Renderer(w, r, http.StatusForbidden,
+					ErrClientCertRequired)
+				return
+			}
+			if len(options.Issuers) > 0 &&
+				!issuedBy(r.TLS.VerifiedChains, options.Issuers) {
+				options.Renderer(w, r, http.StatusForbidden,
+					ErrIssuerNotAllowed)
+				return
+			}
+			cert := r.TLS.VerifiedChains[0][0]
+			p, err := mapper.MapCertificate(r.Context(), cert)
+			if err == nil && p == nil {
+				err = auth.ErrInvalidCredentials
+			}
+			if errors.Is(err, auth.ErrInvalidCredentials) {
+				options.Renderer(w, r, http.StatusForbidden, err)
+				return
+			}
+			if err != nil {
+				logf(r, "client certificate auth: failed to map %q: %v",
+					cert.Subject.String(), err)
+				options.Renderer(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(),
+				p)))
+		})
+	}
+}
+
+// issuedBy reports whether any of chains contains one of issuers above the
+// leaf certificate.
+func issuedBy(chains [][]*x509.Certificate, issuers []*x509.Certificate) bool {
+	for _, chain := range chains {
+		for _, cert := range chain[1:] {
+			if slices.ContainsFunc(issuers, func(issuer *x509.Certificate) bool {
+				return bytes.Equal(issuer.Raw, cert.Raw)
+			}) {
+				return true
+			}
+		}
+	}
+	return false
+}
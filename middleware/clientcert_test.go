@@ -0,0 +1,152 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/candango/httpok/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template,
+		&key.PublicKey, key)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return &testCA{cert: cert, key: key}
+}
+
+// issue signs template, filling in the validity, key and usage.
+func (ca *testCA) issue(t *testing.T, template *x509.Certificate,
+	usage x509.ExtKeyUsage) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert,
+		&key.PublicKey, ca.key)
+	assert.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestClientCertAuth(t *testing.T) {
+	serverCA := newTestCA(t, "server CA")
+	internalCA := newTestCA(t, "internal CA")
+	partnerCA := newTestCA(t, "partner CA")
+	spiffe, _ := url.Parse("spiffe://example.org/ns/prod/sa/billing")
+
+	internal := internalCA.issue(t, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "billing"},
+		URIs:    []*url.URL{spiffe},
+	}, x509.ExtKeyUsageClientAuth)
+	partner := partnerCA.issue(t, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "acme"},
+	}, x509.ExtKeyUsageClientAuth)
+	partnerLeaf, err := x509.ParseCertificate(partner.Certificate[0])
+	assert.NoError(t, err)
+
+	mux := http.NewServeMux()
+	mux.Handle("/internal", ClientCertAuth(auth.MapURISAN("spiffe://example.org/"),
+		&ClientCertOptions{Issuers: []*x509.Certificate{internalCA.cert}})(
+		http.HandlerFunc(whoAmI)))
+	mux.Handle("/partner", ClientCertAuth(auth.NewFingerprintAllowlist(
+		map[string]auth.Principal{
+			auth.CertificateFingerprint(partnerLeaf): {ID: "partner:acme"},
+		}), &ClientCertOptions{Issuers: []*x509.Certificate{partnerCA.cert}})(
+		http.HandlerFunc(whoAmI)))
+	mux.Handle("/any", ClientCertAuth(auth.MapCommonName, nil)(
+		http.HandlerFunc(whoAmI)))
+
+	server := httptest.NewUnstartedServer(mux)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(internalCA.cert)
+	clientCAs.AddCert(partnerCA.cert)
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCA.issue(t, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: "localhost"},
+			IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		}, x509.ExtKeyUsageServerAuth)},
+		ClientCAs:  clientCAs,
+		ClientAuth: tls.VerifyClientCertIfGiven,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	get := func(path string, certs ...tls.Certificate) (int, string) {
+		roots := x509.NewCertPool()
+		roots.AddCert(serverCA.cert)
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs},
+		}}
+		response, err := client.Get(server.URL + path)
+		if !assert.NoError(t, err) {
+			return 0, ""
+		}
+		defer response.Body.Close()
+		body := make([]byte, 512)
+		n, _ := response.Body.Read(body)
+		return response.StatusCode, string(body[:n])
+	}
+
+	status, body := get("/internal", internal)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, spiffe.String(), body)
+	status, _ = get("/internal", partner)
+	assert.Equal(t, http.StatusForbidden, status, "issuer not allowed")
+	status, _ = get("/internal")
+	assert.Equal(t, http.StatusForbidden, status, "no certificate")
+
+	status, body = get("/partner", partner)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "partner:acme", body)
+	otherPartner := partnerCA.issue(t, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "acme"},
+	}, x509.ExtKeyUsageClientAuth)
+	status, _ = get("/partner", otherPartner)
+	assert.Equal(t, http.StatusForbidden, status, "fingerprint not allowed")
+
+	status, body = get("/any", partner)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "acme", body)
+}
+
+func TestClientCertAuthWithoutTLS(t *testing.T) {
+	response := httptest.NewRecorder()
+	ClientCertAuth(auth.MapCommonName, nil)(http.HandlerFunc(whoAmI)).
+		ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusForbidden, response.Code)
+	assert.Empty(t, response.Header().Get("WWW-Authenticate"))
+}
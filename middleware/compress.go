@@ -0,0 +1,291 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultCompressSkipTypes lists the media types the Compress middleware
+// leaves alone because they are already compressed. Entries ending in "/"
+// match every subtype.
+var DefaultCompressSkipTypes = []string{
+	"image/", "audio/", "video/", "font/woff", "font/woff2",
+	"application/gzip", "application/x-gzip", "application/zip",
+	"application/zstd", "application/x-bzip2", "application/x-xz",
+	"application/x-7z-compressed", "application/x-rar-compressed",
+	"application/pdf", "application/wasm",
+}
+
+// CompressOptions configures the Compress middleware.
+type CompressOptions struct {
+	// Level is the compression level: gzip.HuffmanOnly,
+	// gzip.DefaultCompression or a level from gzip.BestSpeed to
+	// gzip.BestCompression. Zero uses gzip.DefaultCompression, so
+	// gzip.NoCompression is not supported; leave Compress out of the chain
+	// to send responses uncompressed. Other levels panic.
+	Level int
+	// MinLength is the smallest body, in bytes, worth compressing. Zero uses
+	// 1024.
+	MinLength int
+	// SkipTypes lists media types sent uncompressed. A nil slice uses
+	// DefaultCompressSkipTypes. "image/svg+xml" is compressed even when
+	// "image/" is listed, since it is text.
+	SkipTypes []string
+}
+
+// Compress creates a middleware compressing responses with gzip or deflate,
+// chosen from the Accept-Encoding q-values, preferring gzip on ties.
+//
+// The body is buffered until MinLength bytes are written, so small responses
+// are sent uncompressed. Responses that already have a Content-Encoding, have
+// a Content-Range, have a skipped media type, or have no body (HEAD, 1xx, 204
+// and 304) are not compressed, nor are upgrade requests. Every response
+// carries Vary: Accept-Encoding. Flushing starts compression right away
+// regardless of MinLength, so streaming handlers keep working, and
+// compressors are pooled across requests. The writer passes the final status
+// down to the next writer, so a WrappedWriter placed outside, as Logging
+// does, still records it.
+func Compress(opts *CompressOptions) func(http.Handler) http.Handler {
+	var options CompressOptions
+	if opts != nil {
+		options = *opts
+	}
+	if options.Level == 0 {
+		options.Level = gzip.DefaultCompression
+	}
+	if options.Level < gzip.HuffmanOnly || options.Level > gzip.BestCompression {
+		panic(fmt.Sprintf("middleware: invalid compression level %d",
+			options.Level))
+	}
+	if options.MinLength == 0 {
+		options.MinLength = 1024
+	}
+	if options.SkipTypes == nil {
+		options.SkipTypes = DefaultCompressSkipTypes
+	}
+	pools := map[string]*sync.Pool{
+		"gzip": {New: func() any {
+			w, _ := gzip.NewWriterLevel(io.Discard, options.Level)
+			return w
+		}},
+		"deflate": {New: func() any {
+			w, _ := zlib.NewWriterLevel(io.Discard, options.Level)
+			return w
+		}},
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			encoding := negotiateEncoding(r.Header.Values("Accept-Encoding"))
+			if encoding == "" || r.Method == http.MethodHead ||
+				r.Header.Get("Upgrade") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cw := &compressWriter{
+				ResponseWriter: w,
+				encoding:       encoding,
+				pool:           pools[encoding],
+				options:        &options,
+			}
+			defer cw.close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// negotiateEncoding picks gzip or deflate from Accept-Encoding values, or
+// returns an empty string when neither is acceptable.
+func negotiateEncoding(values []string) string {
+	q := map[string]float64{}
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			coding, params, _ := strings.Cut(part, ";")
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			if coding == "" {
+				continue
+			}
+			weight := 1.0
+			for _, param := range strings.Split(params, ";") {
+				name, v, ok := strings.Cut(strings.TrimSpace(param), "=")
+				if !ok || strings.ToLower(name) != "q" {
+					continue
+				}
+				parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+				if err != nil || parsed < 0 || parsed > 1 {
+					parsed = 0
+				}
+				weight = parsed
+			}
+			q[coding] = weight
+		}
+	}
+	best, bestQ := "", 0.0
+	for _, coding := range []string{"gzip", "deflate"} {
+		weight, ok := q[coding]
+		if !ok {
+			weight, ok = q["*"]
+		}
+		if ok && weight > bestQ {
+			best, bestQ = coding, weight
+		}
+	}
+	return best
+}
+
+// compressor is implemented by gzip.Writer and zlib.Writer.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// compressWriter buffers the start of a response until it can decide whether
+// to compress it.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding   string
+	pool       *sync.Pool
+	options    *CompressOptions
+	status     int
+	buf        []byte
+	decided    bool
+	compressor compressor
+}
+
+// WriteHeader records the status code. Informational responses are passed
+// through, and statuses without a body settle the decision at once.
+func (w *compressWriter) WriteHeader(code int) {
+	if w.decided || w.status != 0 {
+		return
+	}
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.status = code
+	if code < 200 || code == http.StatusNoContent ||
+		code == http.StatusNotModified {
+		w.decide(false)
+	}
+}
+
+// Write buffers b until MinLength bytes are available, then compresses or
+// passes the body through.
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		w.buf = append(w.buf, b...)
+		if len(w.buf) < w.options.MinLength {
+			return len(b), nil
+		}
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if w.compressor != nil {
+		return w.compressor.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush starts compression without waiting for MinLength, then flushes the
+// compressor and the underlying writer.
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		_ = w.decide(true)
+	}
+	if w.compressor != nil {
+		_ = w.compressor.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter, allowing
+// http.ResponseController to reach optional interfaces it implements.
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// decide writes the headers, compressing when allowed and eligible, and
+// sends the buffered body.
+func (w *compressWriter) decide(allowed bool) error {
+	w.decided = true
+	h := w.Header()
+	if allowed && h.Get("Content-Type") == "" && len(w.buf) != 0 {
+		h.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	if allowed && h.Get("Content-Encoding") == "" &&
+		h.Get("Content-Range") == "" &&
+		!w.skipType(h.Get("Content-Type")) {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", w.encoding)
+		if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			h.Set("ETag", "W/"+etag)
+		}
+		w.compressor = w.pool.Get().(compressor)
+		w.compressor.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(w.buf) == 0 {
+		return nil
+	}
+	buf := w.buf
+	w.buf = nil
+	var err error
+	if w.compressor != nil {
+		_, err = w.compressor.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// close sends a body left below MinLength uncompressed, or finishes the
+// compressed stream and returns the compressor to the pool.
+func (w *compressWriter) close() {
+	if !w.decided {
+		if w.status == 0 {
+			return
+		}
+		_ = w.decide(false)
+	}
+	if w.compressor != nil {
+		_ = w.compressor.Close()
+		w.compressor.Reset(io.Discard)
+		w.pool.Put(w.compressor)
+		w.compressor = nil
+	}
+}
+
+func (w *compressWriter) skipType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(contentType)
+	}
+	if mediaType == "image/svg+xml" {
+		return false
+	}
+	for _, skip := range w.options.SkipTypes {
+		if strings.HasSuffix(skip, "/") && strings.HasPrefix(mediaType, skip) ||
+			mediaType == skip {
+			return true
+		}
+	}
+	return false
+}
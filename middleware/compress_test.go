@@ -0,0 +1,209 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"deflate, gzip", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"GZIP; Q=0.8, deflate;q=0.3", "gzip"},
+		{"gzip;q=0, deflate;q=0", ""},
+		{"*;q=0.1", "gzip"},
+		{"*, gzip;q=0", "deflate"},
+		{"br, identity", ""},
+		{"gzip;q=invalid", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			var values []string
+			if tt.header != "" {
+				values = []string{tt.header}
+			}
+			assert.Equal(t, tt.want, negotiateEncoding(values))
+		})
+	}
+}
+
+func TestCompress(t *testing.T) {
+	body := strings.Repeat("compressible text ", 200)
+	serve := func(acceptEncoding string,
+		handler http.HandlerFunc) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("Accept-Encoding", acceptEncoding)
+		response := httptest.NewRecorder()
+		Compress(nil)(handler).ServeHTTP(response, request)
+		return response
+	}
+
+	response := serve("gzip", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "3600")
+		w.Header().Set("ETag", `"v1"`)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = io.WriteString(w, body)
+	})
+	assert.Equal(t, http.StatusCreated, response.Code)
+	assert.Equal(t, "gzip", response.Header().Get("Content-Encoding"))
+	assert.Equal(t, []string{"Accept-Encoding"}, response.Header().Values("Vary"))
+	assert.Empty(t, response.Header().Get("Content-Length"))
+	assert.Equal(t, `W/"v1"`, response.Header().Get("ETag"))
+	assert.Equal(t, "text/plain; charset=utf-8",
+		response.Header().Get("Content-Type"))
+	reader, err := gzip.NewReader(response.Body)
+	assert.NoError(t, err)
+	decoded, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, body, string(decoded))
+
+	response = serve("deflate", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, body[:1000])
+		_, _ = io.WriteString(w, body[1000:])
+	})
+	assert.Equal(t, "deflate", response.Header().Get("Content-Encoding"))
+	zreader, err := zlib.NewReader(response.Body)
+	assert.NoError(t, err)
+	decoded, err = io.ReadAll(zreader)
+	assert.NoError(t, err)
+	assert.Equal(t, body, string(decoded))
+}
+
+func TestCompressLevels(t *testing.T) {
+	body := strings.Repeat("compressible text ", 200)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, body)
+	})
+	for _, level := range []int{gzip.NoCompression, gzip.HuffmanOnly,
+		gzip.DefaultCompression, gzip.BestSpeed, gzip.BestCompression} {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("Accept-Encoding", "gzip")
+		response := httptest.NewRecorder()
+		Compress(&CompressOptions{Level: level})(handler).ServeHTTP(response,
+			request)
+		assert.Equal(t, "gzip", response.Header().Get("Content-Encoding"))
+		// Zero uses the default level rather than gzip.NoCompression.
+		assert.Less(t, response.Body.Len(), len(body), "level %d", level)
+		reader, err := gzip.NewReader(response.Body)
+		assert.NoError(t, err)
+		decoded, err := io.ReadAll(reader)
+		assert.NoError(t, err)
+		assert.Equal(t, body, string(decoded))
+	}
+
+	for _, level := range []int{gzip.HuffmanOnly - 1, gzip.BestCompression + 1} {
+		assert.Panics(t, func() { Compress(&CompressOptions{Level: level}) })
+	}
+}
+
+func TestCompressSkips(t *testing.T) {
+	body := strings.Repeat("x", 2048)
+	tests := []struct {
+		name    string
+		method  string
+		handler http.HandlerFunc
+	}{
+		{"small body", http.MethodGet,
+			func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.WriteString(w, "small")
+			}},
+		{"compressed type", http.MethodGet,
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "image/png")
+				_, _ = io.WriteString(w, body)
+			}},
+		{"content encoding", http.MethodGet,
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Encoding", "br")
+				_, _ = io.WriteString(w, body)
+			}},
+		{"content range", http.MethodGet,
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Range", "bytes 0-2047/4096")
+				w.WriteHeader(http.StatusPartialContent)
+				_, _ = io.WriteString(w, body)
+			}},
+		{"not modified", http.MethodGet,
+			func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotModified)
+			}},
+		{"head", http.MethodHead,
+			func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.WriteString(w, body)
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(tt.method, "/", nil)
+			request.Header.Set("Accept-Encoding", "gzip")
+			response := httptest.NewRecorder()
+			Compress(nil)(tt.handler).ServeHTTP(response, request)
+			assert.NotEqual(t, "gzip", response.Header().Get("Content-Encoding"))
+			assert.Equal(t, []string{"Accept-Encoding"},
+				response.Header().Values("Vary"))
+		})
+	}
+}
+
+func TestCompressFlush(t *testing.T) {
+	handler := Compress(nil)(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = io.WriteString(w, "data: first\n\n")
+			assert.NoError(t, http.NewResponseController(w).Flush())
+			_, _ = io.WriteString(w, "data: second\n\n")
+		}))
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+
+	assert.True(t, response.Flushed)
+	assert.Equal(t, "gzip", response.Header().Get("Content-Encoding"))
+	reader, err := gzip.NewReader(response.Body)
+	assert.NoError(t, err)
+	decoded, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, "data: first\n\ndata: second\n\n", string(decoded))
+}
+
+func TestCompressWithLogging(t *testing.T) {
+	body := strings.Repeat("logged ", 500)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = io.WriteString(w, body)
+	})
+	for name, order := range map[string]func(*recordingLogger) http.Handler{
+		"logging outside": func(log *recordingLogger) http.Handler {
+			return Chain(next, Logging(log), Compress(nil))
+		},
+		"logging inside": func(log *recordingLogger) http.Handler {
+			return Chain(next, Compress(nil), Logging(log))
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			log := &recordingLogger{}
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.Header.Set("Accept-Encoding", "gzip")
+			response := httptest.NewRecorder()
+			order(log).ServeHTTP(response, request)
+
+			assert.Equal(t, http.StatusAccepted, response.Code)
+			assert.Equal(t, "gzip", response.Header().Get("Content-Encoding"))
+			assert.Contains(t, log.String(), "GET 202 /")
+		})
+	}
+}
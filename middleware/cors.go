@@ -0,0 +1,200 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions configures the CORS middleware.
+type CORSOptions struct {
+	// AllowedOrigins lists the origins allowed to make cross-origin requests.
+	// Entries are exact origins such as "https://app.example.com", wildcard
+	// subdomains such as "https://*.example.com", which do not match the
+	// parent domain, or "*" for any origin.
+	AllowedOrigins []string
+	// AllowOriginFunc is consulted for origins not matched by
+	// AllowedOrigins.
+	AllowOriginFunc func(origin string, r *http.Request) bool
+	// AllowedMethods lists the methods allowed in preflight requests. Empty
+	// allows GET, HEAD and POST.
+	AllowedMethods []string
+	// AllowedHeaders lists the request headers allowed in preflight
+	// requests. "*" allows any header. Empty allows Accept,
+	// Accept-Language, Content-Language, Content-Type and X-Requested-With.
+	AllowedHeaders []string
+	// ExposedHeaders lists the response headers readable by the client.
+	ExposedHeaders []string
+	// AllowCredentials allows cookies and HTTP authentication. With
+	// credentials, the "*" origin is answered with the request origin because
+	// browsers reject a wildcard.
+	AllowCredentials bool
+	// MaxAge is how long a preflight result may be cached. Zero omits the
+	// header, leaving the browser default.
+	MaxAge time.Duration
+}
+
+// CORS creates a middleware implementing Cross-Origin Resource Sharing.
+//
+// Preflight requests, OPTIONS requests carrying Origin and
+// Access-Control-Request-Method, are answered directly with 204 No Content
+// and never reach the next handler. Placed before Sessioned in a Chain, this
+// keeps preflights from creating sessions. Preflights for origins, methods or
+// headers that are not allowed receive no CORS headers, which makes the
+// browser reject the actual request. Actual cross-origin requests are passed
+// to the next handler with the matching CORS response headers, and every
+// response varies on Origin.
+func CORS(opts *CORSOptions) func(http.Handler) http.Handler {
+	var options CORSOptions
+	if opts != nil {
+		options = *opts
+	}
+	policy := newCORSPolicy(options)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			origin := r.Header.Get("Origin")
+			if r.Method == http.MethodOptions &&
+				r.Header.Get("Access-Control-Request-Method") != "" &&
+				origin != "" {
+				h.Add("Vary", "Origin")
+				h.Add("Vary", "Access-Control-Request-Method")
+				h.Add("Vary", "Access-Control-Request-Headers")
+				policy.preflight(h, r, origin)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			h.Add("Vary", "Origin")
+			if origin != "" && policy.allowOrigin(origin, r) {
+				policy.setOrigin(h, origin)
+				if len(options.ExposedHeaders) != 0 {
+					h.Set("Access-Control-Expose-Headers",
+						strings.Join(options.ExposedHeaders, ", "))
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// corsPolicy is the normalized form of CORSOptions.
+type corsPolicy struct {
+	options    CORSOptions
+	anyOrigin  bool
+	origins    map[string]bool
+	wildcards  [][2]string
+	methods    map[string]bool
+	methodList string
+	anyHeader  bool
+	headers    map[string]bool
+}
+
+func newCORSPolicy(options CORSOptions) *corsPolicy {
+	p := &corsPolicy{
+		options: options,
+		origins: map[string]bool{},
+		methods: map[string]bool{},
+		headers: map[string]bool{},
+	}
+	for _, origin := range options.AllowedOrigins {
+		origin = strings.ToLower(strings.TrimSpace(origin))
+		switch {
+		case origin == "*":
+			p.anyOrigin = true
+		case strings.Contains(origin, "://*."):
+			scheme, host, _ := strings.Cut(origin, "://*")
+			p.wildcards = append(p.wildcards, [2]string{scheme + "://", host})
+		default:
+			p.origins[origin] = true
+		}
+	}
+
+	methods := options.AllowedMethods
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+	}
+	for _, method := range methods {
+		p.methods[strings.ToUpper(method)] = true
+	}
+	p.methodList = strings.ToUpper(strings.Join(methods, ", "))
+
+	headers := options.AllowedHeaders
+	if len(headers) == 0 {
+		headers = []string{"Accept", "Accept-Language", "Content-Language",
+			"Content-Type", "X-Requested-With"}
+	}
+	for _, header := range headers {
+		if header == "*" {
+			p.anyHeader = true
+			continue
+		}
+		p.headers[http.CanonicalHeaderKey(header)] = true
+	}
+	return p
+}
+
+// allowOrigin reports whether origin may access the resource.
+func (p *corsPolicy) allowOrigin(origin string, r *http.Request) bool {
+	normalized := strings.ToLower(origin)
+	if p.anyOrigin || p.origins[normalized] {
+		return true
+	}
+	for _, wildcard := range p.wildcards {
+		scheme, suffix := wildcard[0], wildcard[1]
+		if strings.HasPrefix(normalized, scheme) &&
+			strings.HasSuffix(normalized, suffix) &&
+			len(normalized) > len(scheme)+len(suffix) {
+			return true
+		}
+	}
+	return p.options.AllowOriginFunc != nil &&
+		p.options.AllowOriginFunc(origin, r)
+}
+
+// setOrigin writes the allowed origin and credentials headers.
+func (p *corsPolicy) setOrigin(h http.Header, origin string) {
+	if p.anyOrigin && !p.options.AllowCredentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.options.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// preflight writes the preflight response headers when the origin, method
+// and requested headers are all allowed.
+func (p *corsPolicy) preflight(h http.Header, r *http.Request, origin string) {
+	if !p.allowOrigin(origin, r) {
+		return
+	}
+	method := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+	if !p.methods[method] {
+		return
+	}
+	var requested []string
+	for _, value := range r.Header.Values("Access-Control-Request-Headers") {
+		for _, header := range strings.Split(value, ",") {
+			header = strings.TrimSpace(header)
+			if header == "" {
+				continue
+			}
+			if !p.anyHeader && !p.headers[http.CanonicalHeaderKey(header)] {
+				return
+			}
+			requested = append(requested, header)
+		}
+	}
+
+	p.setOrigin(h, origin)
+	h.Set("Access-Control-Allow-Methods", p.methodList)
+	if len(requested) != 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+	}
+	if p.options.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age",
+			strconv.Itoa(int(p.options.MaxAge/time.Second)))
+	}
+}
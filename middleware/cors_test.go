@@ -0,0 +1,141 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/candango/httpok/session"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCORSActualRequests(t *testing.T) {
+	handler := CORS(&CORSOptions{
+		AllowedOrigins: []string{"https://app.example.com",
+			"https://*.example.org"},
+		AllowOriginFunc: func(origin string, r *http.Request) bool {
+			return origin == "https://partner.test"
+		},
+		ExposedHeaders:   []string{"X-Total-Count"},
+		AllowCredentials: true,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://app.example.com", true},
+		{"https://API.example.org", true},
+		{"https://a.b.example.org", true},
+		{"https://example.org", false},
+		{"http://api.example.org", false},
+		{"https://evilexample.org", false},
+		{"https://partner.test", true},
+		{"https://other.test", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.Header.Set("Origin", tt.origin)
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response, request)
+
+			assert.Equal(t, "ok", response.Body.String())
+			assert.Equal(t, []string{"Origin"}, response.Header().Values("Vary"))
+			if tt.allowed {
+				assert.Equal(t, tt.origin,
+					response.Header().Get("Access-Control-Allow-Origin"))
+				assert.Equal(t, "true",
+					response.Header().Get("Access-Control-Allow-Credentials"))
+				assert.Equal(t, "X-Total-Count",
+					response.Header().Get("Access-Control-Expose-Headers"))
+			} else {
+				assert.Empty(t, response.Header().Get("Access-Control-Allow-Origin"))
+			}
+		})
+	}
+}
+
+func TestCORSWildcardOrigin(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set("Origin", "https://any.test")
+
+	response := httptest.NewRecorder()
+	CORS(&CORSOptions{AllowedOrigins: []string{"*"}})(next).ServeHTTP(
+		response, request)
+	assert.Equal(t, "*", response.Header().Get("Access-Control-Allow-Origin"))
+
+	response = httptest.NewRecorder()
+	CORS(&CORSOptions{
+		AllowedOrigins:   []string{"*"},
+		AllowCredentials: true,
+	})(next).ServeHTTP(response, request)
+	assert.Equal(t, "https://any.test",
+		response.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestCORSPreflight(t *testing.T) {
+	store := newCountingStore()
+	reached := false
+	handler := Chain(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reached = true
+		}),
+		CORS(&CORSOptions{
+			AllowedOrigins: []string{"https://app.example.com"},
+			AllowedMethods: []string{"GET", "PUT"},
+			AllowedHeaders: []string{"Content-Type", "X-Api-Key"},
+			MaxAge:         10 * time.Minute,
+		}),
+		Sessioned(session.NewStoreEngine(store)),
+	)
+
+	preflight := func(origin, method, headers string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodOptions, "/", nil)
+		request.Header.Set("Origin", origin)
+		request.Header.Set("Access-Control-Request-Method", method)
+		if headers != "" {
+			request.Header.Set("Access-Control-Request-Headers", headers)
+		}
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		return response
+	}
+
+	response := preflight("https://app.example.com", "PUT",
+		"content-type, x-api-key")
+	assert.Equal(t, http.StatusNoContent, response.Code)
+	assert.Equal(t, "https://app.example.com",
+		response.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET, PUT",
+		response.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "content-type, x-api-key",
+		response.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "600", response.Header().Get("Access-Control-Max-Age"))
+	assert.Equal(t, []string{"Origin", "Access-Control-Request-Method",
+		"Access-Control-Request-Headers"}, response.Header().Values("Vary"))
+
+	for _, denied := range []*httptest.ResponseRecorder{
+		preflight("https://evil.test", "PUT", ""),
+		preflight("https://app.example.com", "DELETE", ""),
+		preflight("https://app.example.com", "PUT", "X-Other"),
+	} {
+		assert.Equal(t, http.StatusNoContent, denied.Code)
+		assert.Empty(t, denied.Header().Get("Access-Control-Allow-Origin"))
+		assert.Empty(t, denied.Header().Get("Access-Control-Allow-Methods"))
+	}
+
+	assert.False(t, reached)
+	assert.Empty(t, response.Header().Values("Set-Cookie"))
+	sets, _ := store.calls()
+	assert.Equal(t, 0, sets)
+
+	request := httptest.NewRequest(http.MethodOptions, "/", nil)
+	request.Header.Set("Origin", "https://app.example.com")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+	assert.True(t, reached, "plain OPTIONS requests reach the handler")
+}
@@ -0,0 +1,208 @@
+package middleware
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"html"
+	"html/template"
+	"net/http"
+
+	"github.com/candango/httpok/session"
+)
+
+const (
+	// CSRFSessionKey is the reserved session data key holding the CSRF
+	// secret and the session ID it is bound to.
+	CSRFSessionKey = "_httpok_csrf"
+	// ContextCSRFValue is the request context key holding the options of the
+	// CSRF middleware serving the request.
+	ContextCSRFValue = "HTTPOKCSRFCTXVALUE"
+	// csrfSecretSize is the number of random bytes in a CSRF secret.
+	csrfSecretSize = 32
+)
+
+// CSRF errors passed to the ErrorRenderer.
+var (
+	ErrCSRFMissing = errors.New("CSRF token missing")
+	ErrCSRFInvalid = errors.New("CSRF token invalid")
+	ErrCSRFSecret  = errors.New("no CSRF secret bound to the session")
+)
+
+// errCSRFNoSession is passed to the ErrorRenderer when CSRF runs outside
+// Sessioned.
+var errCSRFNoSession = errors.New("CSRF requires a session in the context")
+
+// CSRFOptions configures the CSRF middleware.
+type CSRFOptions struct {
+	// FieldName is the form field carrying the token. Empty uses
+	// "csrf_token".
+	FieldName string
+	// HeaderName is the request header carrying the token. Empty uses
+	// "X-CSRF-Token".
+	HeaderName string
+	// Exempt reports whether r skips token validation.
+	Exempt func(r *http.Request) bool
+	// Renderer writes the 403 response, and the 500 response when no
+	// session is available. A nil renderer uses TextErrorRenderer.
+	Renderer ErrorRenderer
+}
+
+// CSRF creates a middleware implementing synchronizer token CSRF protection
+// with a secret kept in the session data.
+//
+// It must run inside Sessioned, for example
+// Chain(handler, Sessioned(engine), CSRF(nil)), so it works with any session
+// store. The secret is created on the first CSRFToken call and stored under
+// CSRFSessionKey together with the session ID it belongs to. A secret bound
+// to a different session ID, as left behind when the session ID is
+// regenerated, is discarded and replaced, so tokens never survive an ID
+// change.
+//
+// Every CSRFToken call returns a differently masked token for the same
+// secret, so tokens can be issued per form or per request. Requests with
+// methods other than GET, HEAD, OPTIONS and TRACE must carry a token in the
+// configured header or form field, otherwise they are answered with 403
+// Forbidden.
+func CSRF(opts *CSRFOptions) func(http.Handler) http.Handler {
+	var options CSRFOptions
+	if opts != nil {
+		options = *opts
+	}
+	if options.FieldName == "" {
+		options.FieldName = "csrf_token"
+	}
+	if options.HeaderName == "" {
+		options.HeaderName = "X-CSRF-Token"
+	}
+	if options.Renderer == nil {
+		options.Renderer = TextErrorRenderer
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Context().Value(session.ContextSessValue) == nil {
+				options.Renderer(w, r, http.StatusInternalServerError,
+					errCSRFNoSession)
+				return
+			}
+			// Safe requests leave the session unloaded under LazySessioned
+			// until a token is requested.
+			if !csrfSafeMethod(r.Method) &&
+				(options.Exempt == nil || !options.Exempt(r)) {
+				sess, err := session.SessionFromContext(r.Context())
+				if err != nil {
+					options.Renderer(w, r, http.StatusInternalServerError, err)
+					return
+				}
+				if err := checkCSRF(r, options, sess); err != nil {
+					options.Renderer(w, r, http.StatusForbidden, err)
+					return
+				}
+			}
+			ctx := context.WithValue(r.Context(), ContextCSRFValue, &options)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// CSRFToken returns a new masked CSRF token for the session in ctx, creating
+// and storing the session secret when needed.
+func CSRFToken(ctx context.Context) (string, error) {
+	sess, err := session.SessionFromContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	secret, ok := csrfSecret(sess)
+	if !ok {
+		secret = make([]byte, csrfSecretSize)
+		_, _ = cryptorand.Read(secret)
+		err := sess.Set(CSRFSessionKey, map[string]any{
+			"secret":  base64.RawURLEncoding.EncodeToString(secret),
+			"session": sess.Id,
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	mask := make([]byte, len(secret))
+	_, _ = cryptorand.Read(mask)
+	token := append(mask, csrfXOR(mask, secret)...)
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// CSRFFormHTML returns a hidden input carrying a new CSRF token, for use in
+// templates. It returns an empty value when the token cannot be created.
+func CSRFFormHTML(ctx context.Context) template.HTML {
+	field := "csrf_token"
+	if options, ok := ctx.Value(ContextCSRFValue).(*CSRFOptions); ok {
+		field = options.FieldName
+	}
+	token, err := CSRFToken(ctx)
+	if err != nil {
+		return ""
+	}
+	return template.HTML(`<input type="hidden" name="` +
+		html.EscapeString(field) + `" value="` + token + `"/>`)
+}
+
+// csrfSecret returns the secret stored in sess when it is bound to the
+// current session ID.
+func csrfSecret(sess *session.Session) ([]byte, bool) {
+	value, err := sess.Get(CSRFSessionKey)
+	if err != nil {
+		return nil, false
+	}
+	stored, ok := value.(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	if id, _ := stored["session"].(string); id != sess.Id {
+		return nil, false
+	}
+	encoded, _ := stored["secret"].(string)
+	secret, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(secret) != csrfSecretSize {
+		return nil, false
+	}
+	return secret, true
+}
+
+// checkCSRF validates the token submitted with r against the session secret.
+func checkCSRF(r *http.Request, options CSRFOptions,
+	sess *session.Session) error {
+	submitted := r.Header.Get(options.HeaderName)
+	if submitted == "" {
+		submitted = r.FormValue(options.FieldName)
+	}
+	if submitted == "" {
+		return ErrCSRFMissing
+	}
+	secret, ok := csrfSecret(sess)
+	if !ok {
+		return ErrCSRFSecret
+	}
+	token, err := base64.RawURLEncoding.DecodeString(submitted)
+	if err != nil || len(token) != 2*len(secret) {
+		return ErrCSRFInvalid
+	}
+	unmasked := csrfXOR(token[:len(secret)], token[len(secret):])
+	if subtle.ConstantTimeCompare(unmasked, secret) != 1 {
+		return ErrCSRFInvalid
+	}
+	return nil
+}
+
+func csrfXOR(a, b []byte) []byte {
+	out := make([]byte, len(a))
+	for i := range a {
+		out[i] = a[i] ^ b[i]
+	}
+	return out
+}
+
+func csrfSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead ||
+		method == http.MethodOptions || method == http.MethodTrace
+}
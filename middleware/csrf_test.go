@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/candango/httpok/session"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCSRF(t *testing.T) {
+	stores := map[string]func(t *testing.T) session.Store{
+		"memory": func(t *testing.T) session.Store {
+			return session.NewMemoryStore()
+		},
+		"file": func(t *testing.T) session.Store {
+			store := &session.FileStore{Dir: t.TempDir()}
+			assert.NoError(t, store.Start(context.Background()))
+			return store
+		},
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			engine := session.NewStoreEngine(newStore(t))
+			handler := Chain(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if r.Method == http.MethodGet {
+						token, err := CSRFToken(r.Context())
+						assert.NoError(t, err)
+						_, _ = w.Write([]byte(token))
+					}
+				}),
+				Sessioned(engine),
+				CSRF(nil),
+			)
+
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response,
+				httptest.NewRequest(http.MethodGet, "/", nil))
+			cookie := response.Result().Cookies()[0]
+			token := response.Body.String()
+
+			get := func(cookie *http.Cookie) string {
+				request := httptest.NewRequest(http.MethodGet, "/", nil)
+				request.AddCookie(cookie)
+				response := httptest.NewRecorder()
+				handler.ServeHTTP(response, request)
+				return response.Body.String()
+			}
+			post := func(cookie *http.Cookie, header, field string) int {
+				form := url.Values{}
+				if field != "" {
+					form.Set("csrf_token", field)
+				}
+				request := httptest.NewRequest(http.MethodPost, "/",
+					strings.NewReader(form.Encode()))
+				request.Header.Set("Content-Type",
+					"application/x-www-form-urlencoded")
+				if header != "" {
+					request.Header.Set("X-CSRF-Token", header)
+				}
+				request.AddCookie(cookie)
+				response := httptest.NewRecorder()
+				handler.ServeHTTP(response, request)
+				return response.Code
+			}
+
+			second := get(cookie)
+			assert.NotEqual(t, token, second, "tokens are masked per request")
+			assert.Equal(t, http.StatusOK, post(cookie, token, ""))
+			assert.Equal(t, http.StatusOK, post(cookie, "", second))
+			assert.Equal(t, http.StatusForbidden, post(cookie, "", ""))
+			tampered := "A" + token[1:]
+			if token[0] == 'A' {
+				tampered = "B" + token[1:]
+			}
+			assert.Equal(t, http.StatusForbidden, post(cookie, tampered, ""))
+			assert.Equal(t, http.StatusForbidden, post(cookie, "%%%", ""))
+
+			// Copying the data to a new session ID, as regeneration does,
+			// invalidates the tokens issued for the old ID.
+			ctx := context.WithValue(context.Background(),
+				session.ContextEngValue, engine)
+			oldID, ok := sessionIDFromCookie(engine, cookie.Value)
+			assert.True(t, ok)
+			sess, err := engine.GetSession(ctx, oldID)
+			assert.NoError(t, err)
+			newID := engine.NewId(ctx)
+			sess.Id = newID
+			assert.NoError(t, engine.SaveSession(ctx, newID, sess))
+			newCookie := sessionCookie(engine, newID)
+
+			assert.Equal(t, http.StatusForbidden, post(newCookie, token, ""))
+			rotated := get(newCookie)
+			assert.Equal(t, http.StatusForbidden, post(newCookie, token, ""))
+			assert.Equal(t, http.StatusOK, post(newCookie, rotated, ""))
+		})
+	}
+}
+
+func TestCSRFRequiresSession(t *testing.T) {
+	handler := CSRF(nil)(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusInternalServerError, response.Code)
+
+	assert.Empty(t, CSRFFormHTML(context.Background()))
+}
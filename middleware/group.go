@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http"
+)
+
+// Group registers handlers on a ServeMux wrapped in a shared list of
+// middleware, so a set of routes can share requirements such as
+// RequireAuth or Authorize:
+//
+//	admin := NewGroup(mux, RequireAuth(nil), Authorize(nil, auth.HasRole("admin")))
+//	admin.HandleFunc("GET /admin/users", listUsers)
+//	admin.With(Authorize(nil, auth.HasPermission("users:delete"))).
+//		HandleFunc("DELETE /admin/users/{id}", deleteUser)
+type Group struct {
+	mux         *http.ServeMux
+	middlewares []Middleware
+}
+
+// NewGroup creates a Group registering on mux with ms applied in order, as
+// in Chain.
+func NewGroup(mux *http.ServeMux, ms ...Middleware) *Group {
+	return &Group{mux: mux, middlewares: ms}
+}
+
+// With returns a Group registering on the same ServeMux with ms applied
+// after the middleware of g.
+func (g *Group) With(ms ...Middleware) *Group {
+	return &Group{
+		mux:         g.mux,
+		middlewares: append(append([]Middleware{}, g.middlewares...), ms...),
+	}
+}
+
+// Handle registers handler for pattern wrapped in the middleware of g. The
+// matched pattern is recorded for Metrics and Tracing, as with RecordRoute.
+func (g *Group) Handle(pattern string, handler http.Handler) {
+	g.mux.Handle(pattern, RecordRoute(Chain(handler, g.middlewares...)))
+}
+
+// HandleFunc registers handler for pattern wrapped in the middleware of g.
+func (g *Group) HandleFunc(pattern string,
+	handler func(http.ResponseWriter, *http.Request)) {
+	g.Handle(pattern, http.HandlerFunc(handler))
+}
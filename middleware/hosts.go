@@ -0,0 +1,191 @@
+package middleware
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/candango/httpok"
+)
+
+// ErrHostNotAllowed is passed to the ErrorRenderer for requests rejected by
+// AllowedHosts, and for requests HTTPSRedirect cannot redirect because their
+// host is invalid.
+var ErrHostNotAllowed = errors.New("host not allowed")
+
+// AllowedHostsOptions configures the AllowedHosts middleware.
+type AllowedHostsOptions struct {
+	// Renderer writes the 400 response. A nil renderer uses
+	// TextErrorRenderer.
+	Renderer ErrorRenderer
+}
+
+// AllowedHosts creates a middleware rejecting requests for hosts not in
+// hosts with 400 Bad Request.
+//
+// Entries are host names such as "example.com", compared case-insensitively
+// and ignoring the port, or wildcards such as "*.example.com", which match
+// every subdomain but not the parent domain. The host is taken from
+// httpok.RequestHost, so it honors ProxyHeaders.
+func AllowedHosts(hosts []string,
+	opts *AllowedHostsOptions) func(http.Handler) http.Handler {
+	var options AllowedHostsOptions
+	if opts != nil {
+		options = *opts
+	}
+	if options.Renderer == nil {
+		options.Renderer = TextErrorRenderer
+	}
+	exact := map[string]bool{}
+	var suffixes []string
+	for _, host := range hosts {
+		host = normalizeHostname(host)
+		if strings.HasPrefix(host, "*.") {
+			suffixes = append(suffixes, host[1:])
+			continue
+		}
+		exact[host] = true
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			name, _ := splitHost(httpok.RequestHost(r))
+			name = normalizeHostname(name)
+			allowed := exact[name]
+			for _, suffix := range suffixes {
+				allowed = allowed || (strings.HasSuffix(name, suffix) &&
+					len(name) > len(suffix))
+			}
+			if !allowed {
+				options.Renderer(w, r, http.StatusBadRequest, ErrHostNotAllowed)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// HostRedirectOptions configures the CanonicalHost middleware.
+type HostRedirectOptions struct {
+	// Status is the redirect status code. Zero uses 301 Moved Permanently.
+	Status int
+	// Exempt reports whether r is served without redirecting, for example
+	// health checks addressed to an internal host name.
+	Exempt func(r *http.Request) bool
+}
+
+// CanonicalHost creates a middleware redirecting requests for any host other
+// than host, such as "www.example.com", to the same scheme and path on host.
+// It can add or remove the "www" prefix or move traffic off old domains. The
+// scheme and host are taken from httpok.RequestScheme and
+// httpok.RequestHost, so they honor ProxyHeaders.
+//
+// Host names are compared ignoring the port. The redirect keeps a request
+// port other than the scheme default, unless host sets its own port.
+func CanonicalHost(host string,
+	opts *HostRedirectOptions) func(http.Handler) http.Handler {
+	var options HostRedirectOptions
+	if opts != nil {
+		options = *opts
+	}
+	if options.Status == 0 {
+		options.Status = http.StatusMovedPermanently
+	}
+	canonical, canonicalPort := splitHost(host)
+	canonical = normalizeHostname(canonical)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			name, port := splitHost(httpok.RequestHost(r))
+			if normalizeHostname(name) == canonical ||
+				(options.Exempt != nil && options.Exempt(r)) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			scheme := httpok.RequestScheme(r)
+			if canonicalPort != "" {
+				port = canonicalPort
+			}
+			target := canonical
+			if strings.Contains(target, ":") {
+				target = "[" + target + "]"
+			}
+			if port != "" && !(scheme == "http" && port == "80") &&
+				!(scheme == "https" && port == "443") {
+				target += ":" + port
+			}
+			http.Redirect(w, r, scheme+"://"+target+r.URL.RequestURI(),
+				options.Status)
+		})
+	}
+}
+
+// HTTPSRedirectOptions configures the HTTPSRedirect middleware.
+type HTTPSRedirectOptions struct {
+	// Status is the redirect status code. Zero uses 308 Permanent Redirect,
+	// which keeps the request method and body.
+	Status int
+	// Port is the HTTPS port in redirect URLs. Zero uses 443, which is left
+	// out of the URL.
+	Port int
+	// Exempt reports whether r is served over plain HTTP, for example ACME
+	// challenges or load balancer health checks.
+	Exempt func(r *http.Request) bool
+	// Renderer writes the 400 response for requests with an invalid host. A
+	// nil renderer uses TextErrorRenderer.
+	Renderer ErrorRenderer
+}
+
+// HTTPSRedirect creates a middleware redirecting plain HTTP requests to the
+// same host and path over HTTPS. The scheme and host are taken from
+// httpok.RequestScheme and httpok.RequestHost, so requests that reached a
+// trusted TLS-terminating proxy are not redirected. Place AllowedHosts before
+// it, as the redirect target uses the request host.
+func HTTPSRedirect(opts *HTTPSRedirectOptions) func(http.Handler) http.Handler {
+	var options HTTPSRedirectOptions
+	if opts != nil {
+		options = *opts
+	}
+	if options.Status == 0 {
+		options.Status = http.StatusPermanentRedirect
+	}
+	if options.Renderer == nil {
+		options.Renderer = TextErrorRenderer
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if httpok.RequestScheme(r) == "https" ||
+				(options.Exempt != nil && options.Exempt(r)) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			name, _ := splitHost(httpok.RequestHost(r))
+			if forwardedHost(name) == "" {
+				options.Renderer(w, r, http.StatusBadRequest, ErrHostNotAllowed)
+				return
+			}
+			if strings.Contains(name, ":") {
+				name = "[" + name + "]"
+			}
+			if options.Port != 0 && options.Port != 443 {
+				name += ":" + strconv.Itoa(options.Port)
+			}
+			http.Redirect(w, r, "https://"+name+r.URL.RequestURI(),
+				options.Status)
+		})
+	}
+}
+
+// splitHost splits host into its name and port, accepting hosts without a
+// port and bracketed IPv6 addresses.
+func splitHost(host string) (string, string) {
+	if name, port, err := net.SplitHostPort(host); err == nil {
+		return name, port
+	}
+	return strings.Trim(host, "[]"), ""
+}
+
+// normalizeHostname lowercases name and removes a trailing dot.
+func normalizeHostname(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllowedHosts(t *testing.T) {
+	handler := AllowedHosts([]string{"example.com", "*.example.org"}, nil)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		host string
+		want int
+	}{
+		{"example.com", http.StatusOK},
+		{"EXAMPLE.com:8080", http.StatusOK},
+		{"example.com.", http.StatusOK},
+		{"www.example.com", http.StatusBadRequest},
+		{"api.example.org", http.StatusOK},
+		{"a.b.example.org:443", http.StatusOK},
+		{"example.org", http.StatusBadRequest},
+		{"evilexample.org", http.StatusBadRequest},
+		{"", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.Host = tt.host
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response, request)
+			assert.Equal(t, tt.want, response.Code)
+		})
+	}
+}
+
+func TestCanonicalHost(t *testing.T) {
+	handler := CanonicalHost("www.example.com", &HostRedirectOptions{
+		Exempt: func(r *http.Request) bool { return r.URL.Path == "/healthz" },
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func(target string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, target,
+			nil))
+		return response
+	}
+
+	response := serve("http://example.com/a?b=c")
+	assert.Equal(t, http.StatusMovedPermanently, response.Code)
+	assert.Equal(t, "http://www.example.com/a?b=c",
+		response.Header().Get("Location"))
+
+	assert.Equal(t, http.StatusOK, serve("http://WWW.example.com/a").Code)
+	assert.Equal(t, http.StatusOK, serve("http://10.0.0.1/healthz").Code)
+	assert.Equal(t, http.StatusOK, serve("http://www.example.com:8080/a").Code)
+	assert.Equal(t, http.StatusOK, serve("https://www.example.com:443/a").Code)
+
+	for target, location := range map[string]string{
+		"http://example.com:8080/a":  "http://www.example.com:8080/a",
+		"http://example.com:80/a":    "http://www.example.com/a",
+		"https://example.com:443/a":  "https://www.example.com/a",
+		"https://example.com:8443/a": "https://www.example.com:8443/a",
+	} {
+		response = serve(target)
+		assert.Equal(t, http.StatusMovedPermanently, response.Code, target)
+		assert.Equal(t, location, response.Header().Get("Location"), target)
+	}
+
+	handler = CanonicalHost("www.example.com:8443", nil)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	response = serve("https://example.com/a")
+	assert.Equal(t, "https://www.example.com:8443/a",
+		response.Header().Get("Location"), "the configured port is used")
+}
+
+func TestHTTPSRedirect(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := Chain(next,
+		ProxyHeaders(&ProxyHeadersOptions{
+			TrustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+		}),
+		HTTPSRedirect(&HTTPSRedirectOptions{
+			Exempt: func(r *http.Request) bool {
+				return r.URL.Path == "/.well-known/acme-challenge/token"
+			},
+		}),
+	)
+
+	request := httptest.NewRequest(http.MethodPost, "http://example.com:80/p?q=1",
+		nil)
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	assert.Equal(t, http.StatusPermanentRedirect, response.Code)
+	assert.Equal(t, "https://example.com/p?q=1", response.Header().Get("Location"))
+
+	request = httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	request.RemoteAddr = "10.0.0.2:1234"
+	request.Header.Set("X-Forwarded-For", "203.0.113.5")
+	request.Header.Set("X-Forwarded-Proto", "https")
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	assert.Equal(t, http.StatusOK, response.Code,
+		"requests that reached the proxy over https are not redirected")
+
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet,
+		"http://example.com/.well-known/acme-challenge/token", nil))
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	response = httptest.NewRecorder()
+	HTTPSRedirect(&HTTPSRedirectOptions{
+		Status: http.StatusFound,
+		Port:   8443,
+	})(next).ServeHTTP(response, httptest.NewRequest(http.MethodGet,
+		"http://[::1]:8080/x", nil))
+	assert.Equal(t, http.StatusFound, response.Code)
+	assert.Equal(t, "https://[::1]:8443/x", response.Header().Get("Location"))
+
+	var rendered error
+	request = httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	request.Host = "bad host"
+	response = httptest.NewRecorder()
+	HTTPSRedirect(&HTTPSRedirectOptions{
+		Renderer: func(w http.ResponseWriter, r *http.Request, status int,
+			err error) {
+			rendered = err
+			w.WriteHeader(status)
+		},
+	})(next).ServeHTTP(response, request)
+	assert.Equal(t, http.StatusBadRequest, response.Code)
+	assert.ErrorIs(t, rendered, ErrHostNotAllowed)
+	assert.Empty(t, response.Header().Get("Location"))
+}
@@ -0,0 +1,215 @@
+package middleware
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/candango/httpok/auth"
+)
+
+// BasicAuthOptions configures the BasicAuth middleware.
+type BasicAuthOptions struct {
+	// Realm is the protection space sent in the challenge. Empty uses
+	// "Restricted".
+	Realm string
+	// Renderer writes the 401 and 500 responses. A nil renderer uses
+	// TextErrorRenderer.
+	Renderer ErrorRenderer
+}
+
+// BasicAuth creates a middleware authenticating requests with HTTP Basic
+// authentication (RFC 7617) through verifier.
+//
+// Requests without valid credentials get 401 Unauthorized with a
+// `Basic realm="...", charset="UTF-8"` challenge. The principal of
+// authenticated requests is stored with auth.WithPrincipal, so
+// auth.CurrentUser, Authorize and KeyByUser work as with session logins.
+// Verifier errors other than auth.ErrInvalidCredentials are logged and
+// answered with 500 Internal Server Error.
+func BasicAuth(verifier auth.BasicVerifier,
+	opts *BasicAuthOptions) func(http.Handler) http.Handler {
+	var options BasicAuthOptions
+	if opts != nil {
+		options = *opts
+	}
+	if options.Realm == "" {
+		options.Realm = "Restricted"
+	}
+	if options.Renderer == nil {
+		options.Renderer = TextErrorRenderer
+	}
+	challenge := "Basic realm=" + quoteAuthParam(options.Realm) +
+		`, charset="UTF-8"`
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			username, password, ok := basicCredentials(r)
+			if !ok {
+				w.Header().Set("WWW-Authenticate", challenge)
+				options.Renderer(w, r, http.StatusUnauthorized,
+					ErrUnauthenticated)
+				return
+			}
+			p, err := verifier.VerifyBasic(r.Context(), username, password)
+			if err == nil && p == nil {
+				err = auth.ErrInvalidCredentials
+			}
+			if errors.Is(err, auth.ErrInvalidCredentials) {
+				w.Header().Set("WWW-Authenticate", challenge)
+				options.Renderer(w, r, http.StatusUnauthorized, err)
+				return
+			}
+			if err != nil {
+				logf(r, "basic auth: failed to verify credentials: %v", err)
+				options.Renderer(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(),
+				p)))
+		})
+	}
+}
+
+// BearerAuthOptions configures the BearerAuth middleware.
+type BearerAuthOptions struct {
+	// Realm is the protection space sent in challenges. Empty sends no
+	// realm.
+	Realm string
+	// Scope is the space-delimited scope sent in challenges. Empty sends no
+	// scope.
+	Scope string
+	// Renderer writes the 400, 401 and 500 responses. A nil renderer uses
+	// TextErrorRenderer.
+	Renderer ErrorRenderer
+}
+
+// BearerAuth creates a middleware authenticating requests with bearer tokens
+// in the Authorization header (RFC 6750) through verifier.
+//
+// Requests without a token get 401 Unauthorized with a bare Bearer
+// challenge, malformed Authorization headers get 400 Bad Request with
+// error="invalid_request", and rejected tokens get 401 with
+// error="invalid_token". The principal of authenticated requests is stored
+// with auth.WithPrincipal. Verifier errors other than
+// auth.ErrInvalidCredentials are logged and answered with 500 Internal
+// Server Error.
+func BearerAuth(verifier auth.TokenVerifier,
+	opts *BearerAuthOptions) func(http.Handler) http.Handler {
+	var options BearerAuthOptions
+	if opts != nil {
+		options = *opts
+	}
+	if options.Renderer == nil {
+		options.Renderer = TextErrorRenderer
+	}
+	var params []string
+	if options.Realm != "" {
+		params = append(params, "realm="+quoteAuthParam(options.Realm))
+	}
+	if options.Scope != "" {
+		params = append(params, "scope="+quoteAuthParam(options.Scope))
+	}
+	challenge := func(code string) string {
+		params := params
+		if code != "" {
+			params = append(params[:len(params):len(params)],
+				"error="+quoteAuthParam(code))
+		}
+		if len(params) == 0 {
+			return "Bearer"
+		}
+		return "Bearer " + strings.Join(params, ", ")
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			values := r.Header.Values("Authorization")
+			if len(values) == 0 || (len(values) == 1 &&
+				!hasAuthScheme(values[0], "Bearer")) {
+				w.Header().Set("WWW-Authenticate", challenge(""))
+				options.Renderer(w, r, http.StatusUnauthorized,
+					ErrUnauthenticated)
+				return
+			}
+			token, ok := bearerToken(values)
+			if !ok {
+				w.Header().Set("WWW-Authenticate", challenge("invalid_request"))
+				options.Renderer(w, r, http.StatusBadRequest,
+					auth.ErrInvalidCredentials)
+				return
+			}
+			p, err := verifier.VerifyToken(r.Context(), token)
+			if err == nil && p == nil {
+				err = auth.ErrInvalidCredentials
+			}
+			if errors.Is(err, auth.ErrInvalidCredentials) {
+				w.Header().Set("WWW-Authenticate", challenge("invalid_token"))
+				options.Renderer(w, r, http.StatusUnauthorized, err)
+				return
+			}
+			if err != nil {
+				logf(r, "bearer auth: failed to verify token: %v", err)
+				options.Renderer(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(),
+				p)))
+		})
+	}
+}
+
+// basicCredentials decodes the Basic credentials of r. Unlike
+// http.Request.BasicAuth it rejects requests with several Authorization
+// headers.
+func basicCredentials(r *http.Request) (string, string, bool) {
+	values := r.Header.Values("Authorization")
+	if len(values) != 1 {
+		return "", "", false
+	}
+	if !hasAuthScheme(values[0], "Basic") {
+		return "", "", false
+	}
+	_, encoded, _ := strings.Cut(values[0], " ")
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
+}
+
+// bearerToken returns the token of a single "Bearer" Authorization header,
+// checking it has the b64token syntax of RFC 6750.
+func bearerToken(values []string) (string, bool) {
+	if len(values) != 1 {
+		return "", false
+	}
+	if !hasAuthScheme(values[0], "Bearer") {
+		return "", false
+	}
+	_, token, _ := strings.Cut(values[0], " ")
+	token = strings.TrimLeft(token, " ")
+	trimmed := strings.TrimRight(token, "=")
+	if trimmed == "" {
+		return "", false
+	}
+	for _, c := range trimmed {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' ||
+			'0' <= c && c <= '9' || strings.ContainsRune("-._~+/", c)) {
+			return "", false
+		}
+	}
+	return token, true
+}
+
+// hasAuthScheme reports whether the Authorization header value uses scheme,
+// compared case-insensitively.
+func hasAuthScheme(value, scheme string) bool {
+	name, _, ok := strings.Cut(value, " ")
+	return ok && strings.EqualFold(name, scheme)
+}
+
+// quoteAuthParam returns value as an auth-param quoted-string.
+func quoteAuthParam(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+}
@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/candango/httpok/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+func whoAmI(w http.ResponseWriter, r *http.Request) {
+	p, _ := auth.CurrentUser(r.Context())
+	_, _ = w.Write([]byte(p.ID))
+}
+
+func TestBasicAuth(t *testing.T) {
+	htpasswd, err := auth.ParseHtpasswd(strings.NewReader(
+		"alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="), nil)
+	assert.NoError(t, err)
+	handler := BasicAuth(htpasswd, &BasicAuthOptions{Realm: `Admin "area"`})(
+		http.HandlerFunc(whoAmI))
+	challenge := `Basic realm="Admin \"area\"", charset="UTF-8"`
+
+	serve := func(header ...string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		for _, value := range header {
+			request.Header.Add("Authorization", value)
+		}
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		return response
+	}
+	basic := func(credentials string) string {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		user, password, _ := strings.Cut(credentials, ":")
+		request.SetBasicAuth(user, password)
+		return request.Header.Get("Authorization")
+	}
+
+	response := serve(basic("alice:secret"))
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "alice", response.Body.String())
+	response = serve("basic " + strings.TrimPrefix(basic("alice:secret"),
+		"Basic "))
+	assert.Equal(t, http.StatusOK, response.Code, "scheme is case-insensitive")
+
+	for name, header := range map[string][]string{
+		"missing":        nil,
+		"wrong password": {basic("alice:wrong")},
+		"unknown user":   {basic("bob:secret")},
+		"bad base64":     {"Basic %%%"},
+		"no colon":       {"Basic YWxpY2U="},
+		"other scheme":   {"Bearer abc"},
+		"two headers":    {basic("alice:secret"), basic("alice:secret")},
+	} {
+		response := serve(header...)
+		assert.Equal(t, http.StatusUnauthorized, response.Code, name)
+		assert.Equal(t, challenge, response.Header().Get("WWW-Authenticate"),
+			name)
+	}
+}
+
+func TestBearerAuth(t *testing.T) {
+	tokens := auth.NewStaticTokens(map[string]auth.Principal{
+		"mF_9.B5f-4.1JqM": {ID: "ci"},
+	})
+	failing := auth.TokenVerifierFunc(func(context.Context,
+		string) (*auth.Principal, error) {
+		return nil, errors.New("introspection endpoint down")
+	})
+	options := &BearerAuthOptions{Realm: "api", Scope: "read write"}
+
+	serve := func(verifier auth.TokenVerifier,
+		header ...string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		for _, value := range header {
+			request.Header.Add("Authorization", value)
+		}
+		response := httptest.NewRecorder()
+		BearerAuth(verifier, options)(http.HandlerFunc(whoAmI)).
+			ServeHTTP(response, request)
+		return response
+	}
+
+	response := serve(tokens, "Bearer mF_9.B5f-4.1JqM")
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "ci", response.Body.String())
+
+	tests := []struct {
+		name   string
+		header []string
+		status int
+		want   string
+	}{
+		{"missing", nil, http.StatusUnauthorized,
+			`Bearer realm="api", scope="read write"`},
+		{"other scheme", []string{"Basic YWxpY2U6c2VjcmV0"},
+			http.StatusUnauthorized, `Bearer realm="api", scope="read write"`},
+		{"invalid token", []string{"Bearer nope"}, http.StatusUnauthorized,
+			`Bearer realm="api", scope="read write", error="invalid_token"`},
+		{"malformed token", []string{"Bearer a b"}, http.StatusBadRequest,
+			`Bearer realm="api", scope="read write", error="invalid_request"`},
+		{"empty token", []string{"Bearer "}, http.StatusBadRequest,
+			`Bearer realm="api", scope="read write", error="invalid_request"`},
+		{"two headers", []string{"Bearer a", "Bearer b"},
+			http.StatusBadRequest,
+			`Bearer realm="api", scope="read write", error="invalid_request"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := serve(tokens, tt.header...)
+			assert.Equal(t, tt.status, response.Code)
+			assert.Equal(t, tt.want, response.Header().Get("WWW-Authenticate"))
+		})
+	}
+
+	response = serve(failing, "Bearer abc")
+	assert.Equal(t, http.StatusInternalServerError, response.Code)
+	assert.Empty(t, response.Header().Get("WWW-Authenticate"))
+}
+
+func TestBearerAuthWithAuthorize(t *testing.T) {
+	tokens := auth.NewStaticTokens(map[string]auth.Principal{
+		"admin-token": {ID: "ops", Roles: []string{"admin"}},
+		"user-token":  {ID: "ci"},
+	})
+	handler := Chain(http.HandlerFunc(whoAmI),
+		BearerAuth(tokens, nil),
+		Authorize(nil, auth.HasRole("admin")),
+	)
+	for token, want := range map[string]int{
+		"admin-token": http.StatusOK,
+		"user-token":  http.StatusForbidden,
+	} {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		assert.Equal(t, want, response.Code, token)
+	}
+}
@@ -10,7 +10,8 @@ import (
 
 // Logging creates a logging middleware with a custom logger.
 //
-// It records the request method, path, response status, and elapsed time. A
+// It records the request method, path, response status, and elapsed time,
+// followed by the request ID when the RequestID middleware runs before it. A
 // nil logger uses the standard logger.
 func Logging(log logger.Logger) func(http.Handler) http.Handler {
 	if log == nil {
@@ -26,16 +27,20 @@ func Logging(log logger.Logger) func(http.Handler) http.Handler {
 			f := "[02/01/2006:03:04:05 07]"
 			next.ServeHTTP(wrapped, r)
 			s := time.Now().Format(f)
+			format := "%s %s %d %s %d"
+			v := []any{s, r.Method, wrapped.StatusCode, r.URL.Path,
+				time.Since(start).Microseconds()}
+			if id := httpok.RequestIDFromContext(r.Context()); id != "" {
+				format += " %s"
+				v = append(v, id)
+			}
 			switch {
 			case wrapped.StatusCode >= 500:
-				log.Errorf("%s %s %d %s %d", s, r.Method, wrapped.StatusCode,
-					r.URL.Path, time.Since(start).Microseconds())
+				log.Errorf(format, v...)
 			case wrapped.StatusCode >= 400:
-				log.Warnf("%s %s %d %s %d", s, r.Method, wrapped.StatusCode,
-					r.URL.Path, time.Since(start).Microseconds())
+				log.Warnf(format, v...)
 			default:
-				log.Printf("%s %s %d %s %d", s, r.Method, wrapped.StatusCode,
-					r.URL.Path, time.Since(start).Microseconds())
+				log.Printf(format, v...)
 			}
 		})
 	}
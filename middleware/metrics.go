@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/candango/httpok"
+	"github.com/candango/httpok/metrics"
+)
+
+// MetricsOptions configures the Metrics middleware.
+type MetricsOptions struct {
+	// Namespace prefixes the metric names. Empty uses "httpok".
+	Namespace string
+	// Buckets are the latency histogram bounds in seconds. A nil slice uses
+	// metrics.DefaultBuckets.
+	Buckets []float64
+	// Route returns the route label for a served request. A nil function
+	// uses RoutePattern, or "unmatched" when no pattern was matched. Avoid
+	// raw paths: every distinct label value creates a new series.
+	Route func(*http.Request) string
+}
+
+// Metrics creates a middleware recording request metrics into registry:
+//
+//   - <namespace>_http_requests_total, a counter by method, route and status
+//     class (2xx, 4xx, ...);
+//   - <namespace>_http_request_duration_seconds, a latency histogram with the
+//     same labels;
+//   - <namespace>_http_requests_in_flight, a gauge of requests being served.
+//
+// Expose the registry with metrics.Handler. A nil options value uses the
+// defaults. Wrap the mux with RecordRoute when middleware between Metrics and
+// the mux replaces the request, or every route is labeled "unmatched".
+func Metrics(registry *metrics.Registry,
+	opts *MetricsOptions) func(http.Handler) http.Handler {
+	var options MetricsOptions
+	if opts != nil {
+		options = *opts
+	}
+	if options.Namespace == "" {
+		options.Namespace = "httpok"
+	}
+	if options.Route == nil {
+		options.Route = patternRoute
+	}
+	requests := registry.NewCounter(options.Namespace+"_http_requests_total",
+		"Total number of HTTP requests served.",
+		"method", "route", "status_class")
+	duration := registry.NewHistogram(
+		options.Namespace+"_http_request_duration_seconds",
+		"HTTP request latency in seconds.", options.Buckets,
+		"method", "route", "status_class")
+	inFlight := registry.NewGauge(options.Namespace+"_http_requests_in_flight",
+		"Number of HTTP requests currently being served.")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			inFlight.Inc()
+			defer inFlight.Dec()
+			wrapped := &httpok.WrappedWriter{
+				ResponseWriter: w,
+				StatusCode:     http.StatusOK,
+			}
+			r = withRoute(r)
+			next.ServeHTTP(wrapped, r)
+			labels := []string{methodLabel(r.Method), options.Route(r),
+				statusClass(wrapped.StatusCode)}
+			requests.Inc(labels...)
+			duration.Observe(time.Since(start).Seconds(), labels...)
+		})
+	}
+}
+
+// patternRoute returns the http.ServeMux pattern matched for r, or
+// "unmatched".
+func patternRoute(r *http.Request) string {
+	if pattern := RoutePattern(r); pattern != "" {
+		return pattern
+	}
+	return "unmatched"
+}
+
+// methodLabel bounds the method label to the standard methods, since clients
+// can send arbitrary ones.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodConnect,
+		http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
+
+func statusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "unknown"
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
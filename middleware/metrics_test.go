@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/candango/httpok/metrics"
+	"github.com/candango/httpok/session"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsMiddleware(t *testing.T) {
+	registry := metrics.NewRegistry()
+	mux := http.NewServeMux()
+	inFlight := ""
+	mux.HandleFunc("GET /items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		var out strings.Builder
+		_ = registry.WriteText(&out)
+		inFlight = out.String()
+		if r.PathValue("id") == "missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	handler := Metrics(registry, nil)(mux)
+
+	for _, path := range []string{"/items/1", "/items/2", "/items/missing",
+		"/other"} {
+		handler.ServeHTTP(httptest.NewRecorder(),
+			httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	request := httptest.NewRequest("BREW", "/items/1", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	var out strings.Builder
+	assert.NoError(t, registry.WriteText(&out))
+	text := out.String()
+	assert.Contains(t, inFlight, "httpok_http_requests_in_flight 1\n")
+	assert.Contains(t, text, `httpok_http_requests_total{method="GET",`+
+		`route="GET /items/{id}",status_class="2xx"} 2`)
+	assert.Contains(t, text, `httpok_http_requests_total{method="GET",`+
+		`route="GET /items/{id}",status_class="4xx"} 1`)
+	assert.Contains(t, text, `httpok_http_requests_total{method="GET",`+
+		`route="unmatched",status_class="4xx"} 1`)
+	assert.Contains(t, text, `httpok_http_requests_total{method="OTHER",`)
+	assert.Contains(t, text, `httpok_http_request_duration_seconds_count{`+
+		`method="GET",route="GET /items/{id}",status_class="2xx"} 2`)
+	assert.Contains(t, text, "httpok_http_requests_in_flight 0\n")
+}
+
+func TestMetricsRouteBehindMiddleware(t *testing.T) {
+	registry := metrics.NewRegistry()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /items/{id}", func(http.ResponseWriter, *http.Request) {})
+	NewGroup(mux, SecurityHeaders(nil)).HandleFunc("GET /grouped/{id}",
+		func(http.ResponseWriter, *http.Request) {})
+	engine := session.NewStoreEngine(session.NewMemoryStore())
+	chain := func(mux http.Handler) http.Handler {
+		return Chain(mux,
+			Metrics(registry, nil),
+			RequestID(nil),
+			Sessioned(engine),
+		)
+	}
+
+	for _, handler := range []http.Handler{chain(RecordRoute(mux)),
+		chain(mux)} {
+		for _, path := range []string{"/items/1", "/grouped/1", "/other"} {
+			handler.ServeHTTP(httptest.NewRecorder(),
+				httptest.NewRequest(http.MethodGet, path, nil))
+		}
+	}
+
+	var out strings.Builder
+	assert.NoError(t, registry.WriteText(&out))
+	text := out.String()
+	assert.Contains(t, text, `httpok_http_requests_total{method="GET",`+
+		`route="GET /items/{id}",status_class="2xx"} 1`, "with RecordRoute")
+	assert.Contains(t, text, `httpok_http_requests_total{method="GET",`+
+		`route="GET /grouped/{id}",status_class="2xx"} 2`, "with a Group")
+	assert.Contains(t, text, `httpok_http_requests_total{method="GET",`+
+		`route="unmatched",status_class="2xx"} 1`)
+	assert.Contains(t, text, `httpok_http_requests_total{method="GET",`+
+		`route="unmatched",status_class="4xx"} 2`)
+}
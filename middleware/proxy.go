@@ -0,0 +1,202 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/candango/httpok"
+)
+
+// ProxyHeadersOptions configures the ProxyHeaders middleware.
+type ProxyHeadersOptions struct {
+	// TrustedProxies lists the networks of the reverse proxies whose headers
+	// are trusted. Headers are ignored when the connection does not come
+	// from one of them.
+	TrustedProxies []netip.Prefix
+}
+
+// forwardedHop is one proxy hop: the address it received the request from
+// and, when known, the scheme and host that request used.
+type forwardedHop struct {
+	addr   string
+	scheme string
+	host   string
+}
+
+// ProxyHeaders creates a middleware resolving the original client IP, scheme
+// and host from reverse proxy headers, storing them as an httpok.ProxyInfo
+// in the request context. Read them with httpok.ClientIP,
+// httpok.RequestScheme and httpok.RequestHost.
+//
+// The Forwarded header (RFC 7239) is used when present, otherwise
+// X-Forwarded-For, X-Forwarded-Proto and X-Forwarded-Host. Headers are only
+// trusted when the connection comes from a TrustedProxies network. The hops
+// are then walked from the nearest proxy back, and the first address outside
+// the trusted networks is the client; the scheme and host reported along
+// with it are used. Addresses that cannot be parsed, such as "unknown" or
+// obfuscated identifiers, stop the walk at the last trusted hop.
+func ProxyHeaders(opts *ProxyHeadersOptions) func(http.Handler) http.Handler {
+	var options ProxyHeadersOptions
+	if opts != nil {
+		options = *opts
+	}
+	trusted := func(addr netip.Addr) bool {
+		addr = addr.Unmap()
+		for _, prefix := range options.TrustedProxies {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			peer, ok := parseForwardedAddr(r.RemoteAddr)
+			if !ok || !trusted(peer) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			hops := forwardedHops(r.Header)
+			if len(hops) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			info := httpok.ProxyInfo{ClientIP: peer.Unmap().String()}
+			for i := len(hops) - 1; i >= 0; i-- {
+				addr, ok := parseForwardedAddr(hops[i].addr)
+				if !ok {
+					break
+				}
+				info.ClientIP = addr.Unmap().String()
+				info.Scheme = hops[i].scheme
+				info.Host = hops[i].host
+				if !trusted(addr) {
+					break
+				}
+			}
+			ctx := httpok.ContextWithProxyInfo(r.Context(), info)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// forwardedHops returns the hops reported in h, client first.
+func forwardedHops(h http.Header) []forwardedHop {
+	var hops []forwardedHop
+	if values := h.Values("Forwarded"); len(values) != 0 {
+		for _, element := range splitQuoted(strings.Join(values, ","), ',') {
+			var hop forwardedHop
+			for _, pair := range splitQuoted(element, ';') {
+				name, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+				value = strings.Trim(value, `"`)
+				switch strings.ToLower(name) {
+				case "for":
+					hop.addr = value
+				case "proto":
+					hop.scheme = forwardedScheme(value)
+				case "host":
+					hop.host = forwardedHost(value)
+				}
+			}
+			hops = append(hops, hop)
+		}
+		return hops
+	}
+
+	for _, addr := range headerList(h, "X-Forwarded-For") {
+		hops = append(hops, forwardedHop{addr: addr})
+	}
+	if len(hops) == 0 {
+		return nil
+	}
+	// Proxies either append to X-Forwarded-Proto and X-Forwarded-Host, in
+	// step with X-Forwarded-For, or set a single value. In the latter case
+	// the nearest proxy's value applies to every hop.
+	for _, header := range []string{"X-Forwarded-Proto", "X-Forwarded-Host"} {
+		values := headerList(h, header)
+		for i := range hops {
+			var value string
+			switch len(values) {
+			case 0:
+				continue
+			case len(hops):
+				value = values[i]
+			default:
+				value = values[len(values)-1]
+			}
+			if header == "X-Forwarded-Proto" {
+				hops[i].scheme = forwardedScheme(value)
+			} else {
+				hops[i].host = forwardedHost(value)
+			}
+		}
+	}
+	return hops
+}
+
+// headerList returns the comma-separated values of every name header.
+func headerList(h http.Header, name string) []string {
+	var list []string
+	for _, value := range h.Values(name) {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+// splitQuoted splits s on sep outside double-quoted strings.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quoted:
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// parseForwardedAddr parses an IP address with an optional port, including
+// the bracketed IPv6 form.
+func parseForwardedAddr(value string) (netip.Addr, bool) {
+	value = strings.TrimSpace(value)
+	if addrPort, err := netip.ParseAddrPort(value); err == nil {
+		return addrPort.Addr(), true
+	}
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+	addr, err := netip.ParseAddr(strings.Trim(value, "[]"))
+	return addr, err == nil
+}
+
+func forwardedScheme(value string) string {
+	switch value = strings.ToLower(strings.TrimSpace(value)); value {
+	case "http", "https":
+		return value
+	}
+	return ""
+}
+
+// forwardedHost returns value when it looks like a host with an optional
+// port, and an empty string otherwise.
+func forwardedHost(value string) string {
+	value = strings.TrimSpace(value)
+	if value == "" || len(value) > 255 ||
+		strings.ContainsAny(value, "/\\@?#%\" \t") {
+		return ""
+	}
+	return value
+}
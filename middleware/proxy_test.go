@@ -0,0 +1,159 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/candango/httpok"
+	"github.com/candango/httpok/session"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyHeaders(t *testing.T) {
+	var got httpok.ProxyInfo
+	handler := ProxyHeaders(&ProxyHeadersOptions{
+		TrustedProxies: []netip.Prefix{
+			netip.MustParsePrefix("10.0.0.0/8"),
+			netip.MustParsePrefix("2001:db8:ffff::/48"),
+		},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = httpok.ProxyInfo{
+			ClientIP: httpok.ClientIP(r),
+			Scheme:   httpok.RequestScheme(r),
+			Host:     httpok.RequestHost(r),
+		}
+	}))
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     http.Header
+		want       httpok.ProxyInfo
+	}{
+		{
+			name:       "untrusted peer",
+			remoteAddr: "198.51.100.7:1234",
+			header: http.Header{
+				"X-Forwarded-For":   {"203.0.113.5"},
+				"X-Forwarded-Proto": {"https"},
+				"X-Forwarded-Host":  {"evil.test"},
+			},
+			want: httpok.ProxyInfo{ClientIP: "198.51.100.7", Scheme: "http",
+				Host: "app.test"},
+		},
+		{
+			name:       "x-forwarded single proxy",
+			remoteAddr: "10.0.0.2:1234",
+			header: http.Header{
+				"X-Forwarded-For":   {"203.0.113.5"},
+				"X-Forwarded-Proto": {"https"},
+				"X-Forwarded-Host":  {"www.example.com"},
+			},
+			want: httpok.ProxyInfo{ClientIP: "203.0.113.5", Scheme: "https",
+				Host: "www.example.com"},
+		},
+		{
+			name:       "spoofed x-forwarded-for prefix",
+			remoteAddr: "10.0.0.2:1234",
+			header: http.Header{
+				"X-Forwarded-For":   {"1.2.3.4, 203.0.113.5, 10.0.0.9"},
+				"X-Forwarded-Proto": {"https"},
+			},
+			want: httpok.ProxyInfo{ClientIP: "203.0.113.5", Scheme: "https",
+				Host: "app.test"},
+		},
+		{
+			name:       "forwarded",
+			remoteAddr: "[2001:db8:ffff::1]:443",
+			header: http.Header{
+				"Forwarded": {
+					`for=1.2.3.4;proto=http, for="[2001:db8::5]:4711";` +
+						`proto=https;host="shop.example.com"`,
+					"for=10.1.2.3;proto=http;host=internal",
+				},
+				"X-Forwarded-For": {"198.51.100.1"},
+			},
+			want: httpok.ProxyInfo{ClientIP: "2001:db8::5", Scheme: "https",
+				Host: "shop.example.com"},
+		},
+		{
+			name:       "forwarded unknown client",
+			remoteAddr: "10.0.0.2:1234",
+			header: http.Header{
+				"Forwarded": {"for=unknown;proto=https, for=10.0.0.3;proto=http"},
+			},
+			want: httpok.ProxyInfo{ClientIP: "10.0.0.3", Scheme: "http",
+				Host: "app.test"},
+		},
+		{
+			name:       "invalid values",
+			remoteAddr: "10.0.0.2:1234",
+			header: http.Header{
+				"X-Forwarded-For":   {"203.0.113.5"},
+				"X-Forwarded-Proto": {"javascript"},
+				"X-Forwarded-Host":  {"evil.test/path"},
+			},
+			want: httpok.ProxyInfo{ClientIP: "203.0.113.5", Scheme: "http",
+				Host: "app.test"},
+		},
+		{
+			name:       "no headers",
+			remoteAddr: "10.0.0.2:1234",
+			want: httpok.ProxyInfo{ClientIP: "10.0.0.2", Scheme: "http",
+				Host: "app.test"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "http://app.test/",
+				nil)
+			request.RemoteAddr = tt.remoteAddr
+			for name, values := range tt.header {
+				request.Header[name] = values
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), request)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestSessionedAutoSecure(t *testing.T) {
+	engine := session.NewStoreEngine(session.NewMemoryStore(),
+		session.WithProperties(&session.EngineProperties{
+			CookieOptions: &session.CookieOptions{
+				HTTPOnly:   true,
+				AutoSecure: true,
+				SameSite:   http.SameSiteLaxMode,
+			},
+		}))
+	handler := Chain(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		ProxyHeaders(&ProxyHeadersOptions{
+			TrustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+		}),
+		Sessioned(engine),
+	)
+
+	for _, tt := range []struct {
+		remoteAddr string
+		proto      string
+		secure     bool
+	}{
+		{"10.0.0.2:1234", "https", true},
+		{"10.0.0.2:1234", "http", false},
+		{"198.51.100.7:1234", "https", false},
+	} {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.RemoteAddr = tt.remoteAddr
+		request.Header.Set("X-Forwarded-For", "203.0.113.5")
+		request.Header.Set("X-Forwarded-Proto", tt.proto)
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		cookies := response.Result().Cookies()
+		if assert.Len(t, cookies, 1) {
+			assert.Equal(t, tt.secure, cookies[0].Secure, tt)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/candango/httpok"
+	"github.com/candango/httpok/auth"
+	"github.com/candango/httpok/ratelimit"
+)
+
+// ErrRateLimited is passed to the ErrorRenderer for rejected requests.
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// RateLimitKeyFunc returns the key a request is limited by. An empty key
+// exempts the request from the limit.
+type RateLimitKeyFunc func(r *http.Request) string
+
+// KeyByIP limits requests by the client IP address returned by
+// httpok.ClientIP, which honors ProxyHeaders.
+func KeyByIP(r *http.Request) string {
+	host := httpok.ClientIP(r)
+	if host == "" {
+		return ""
+	}
+	return "ip:" + host
+}
+
+// KeyBySession limits requests by the ID of the session the client sent. It
+// must run inside Sessioned or LazySessioned. Requests without an existing
+// session, because their cookie is missing, invalid or names a session the
+// store does not hold, are limited by KeyByIP instead, so a client cannot
+// reset its quota by dropping the session cookie.
+func KeyBySession(r *http.Request) string {
+	if id := clientSessionId(r); id != "" {
+		return "session:" + id
+	}
+	return KeyByIP(r)
+}
+
+// KeyByUser limits requests by the ID of the principal returned by
+// auth.CurrentUser. Anonymous requests are not limited, so combine it with
+// KeyByIP when they must be bounded too.
+func KeyByUser(r *http.Request) string {
+	p, ok := auth.CurrentUser(r.Context())
+	if !ok {
+		return ""
+	}
+	return "user:" + p.ID
+}
+
+// KeyByHeader limits requests by the value of the named header, such as an
+// API key. Requests without the header are not limited, so combine it with
+// another limit when anonymous requests must be bounded too.
+func KeyByHeader(name string) RateLimitKeyFunc {
+	return func(r *http.Request) string {
+		value := r.Header.Get(name)
+		if value == "" {
+			return ""
+		}
+		return "header:" + name + ":" + value
+	}
+}
+
+// RateLimitOptions configures the RateLimit middleware.
+type RateLimitOptions struct {
+	// Key selects the limited key. A nil function uses KeyByIP.
+	Key RateLimitKeyFunc
+	// FailClosed rejects requests with 503 Service Unavailable when the
+	// limiter fails. By default failures are logged and requests proceed.
+	FailClosed bool
+	// Renderer writes the 429 and 503 responses. A nil renderer uses
+	// TextErrorRenderer.
+	Renderer ErrorRenderer
+}
+
+// RateLimit creates a middleware limiting requests with limiter.
+//
+// Limited responses carry RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers, the latter in seconds. Rejected requests receive
+// 429 Too Many Requests with a Retry-After header in seconds. A nil options
+// value uses the defaults.
+func RateLimit(limiter ratelimit.Limiter,
+	opts *RateLimitOptions) func(http.Handler) http.Handler {
+	var options RateLimitOptions
+	if opts != nil {
+		options = *opts
+	}
+	if options.Key == nil {
+		options.Key = KeyByIP
+	}
+	if options.Renderer == nil {
+		options.Renderer = TextErrorRenderer
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := options.Key(r)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			result, err := limiter.Allow(r.Context(), key)
+			if err != nil {
+				if options.FailClosed {
+					options.Renderer(w, r, http.StatusServiceUnavailable, err)
+					return
+				}
+				logf(r, "rate limiter failed: %v", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			h := w.Header()
+			h.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+			h.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			h.Set("RateLimit-Reset", ceilSeconds(result.Reset))
+			if !result.Allowed {
+				h.Set("Retry-After", ceilSeconds(max(result.RetryAfter,
+					time.Second)))
+				options.Renderer(w, r, http.StatusTooManyRequests,
+					ErrRateLimited)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ceilSeconds formats d as whole seconds, rounding up.
+func ceilSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
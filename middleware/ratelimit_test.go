@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/candango/httpok/ratelimit"
+	"github.com/candango/httpok/session"
+	"github.com/stretchr/testify/assert"
+)
+
+type failingLimiter struct{}
+
+func (failingLimiter) Allow(context.Context, string) (ratelimit.Result,
+	error) {
+	return ratelimit.Result{}, errors.New("counter unavailable")
+}
+
+func TestRateLimit(t *testing.T) {
+	limiter := ratelimit.NewTokenBucket(ratelimit.NewMemoryCounter(), 2,
+		time.Minute)
+	handler := RateLimit(limiter, nil)(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func(remoteAddr string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.RemoteAddr = remoteAddr
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		return response
+	}
+
+	response := serve("192.0.2.1:1234")
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "2", response.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", response.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "30", response.Header().Get("RateLimit-Reset"))
+
+	response = serve("192.0.2.1:5678")
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "0", response.Header().Get("RateLimit-Remaining"))
+
+	response = serve("192.0.2.1:1234")
+	assert.Equal(t, http.StatusTooManyRequests, response.Code)
+	assert.Equal(t, "30", response.Header().Get("Retry-After"))
+
+	response = serve("192.0.2.2:1234")
+	assert.Equal(t, http.StatusOK, response.Code)
+}
+
+func TestRateLimitKeys(t *testing.T) {
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.RemoteAddr = "[2001:db8::1]:443"
+	assert.Equal(t, "ip:2001:db8::1", KeyByIP(request))
+
+	assert.Equal(t, "ip:2001:db8::1", KeyBySession(request))
+	sess := &session.Session{Id: "abc"}
+	sessioned := request.WithContext(context.WithValue(request.Context(),
+		session.ContextSessValue, sess))
+	assert.Equal(t, "ip:2001:db8::1", KeyBySession(sessioned))
+
+	byAPIKey := KeyByHeader("X-Api-Key")
+	assert.Empty(t, byAPIKey(request))
+	request.Header.Set("X-Api-Key", "k1")
+	assert.Equal(t, "header:X-Api-Key:k1", byAPIKey(request))
+}
+
+func TestRateLimitKeyBySession(t *testing.T) {
+	for name, sessioned := range map[string]func(session.Engine) func(
+		http.Handler) http.Handler{
+		"eager": Sessioned,
+		"lazy":  LazySessioned,
+	} {
+		t.Run(name, func(t *testing.T) {
+			engine := session.NewStoreEngine(session.NewMemoryStore())
+			limiter := ratelimit.NewTokenBucket(ratelimit.NewMemoryCounter(),
+				1, time.Minute)
+			handler := sessioned(engine)(RateLimit(limiter,
+				&RateLimitOptions{Key: KeyBySession})(http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					sess, err := session.SessionFromContext(r.Context())
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					_ = sess.Set("visited", true)
+				})))
+			serve := func(cookie *http.Cookie) *httptest.ResponseRecorder {
+				request := httptest.NewRequest(http.MethodGet, "/", nil)
+				request.RemoteAddr = "192.0.2.1:1234"
+				if cookie != nil {
+					request.AddCookie(cookie)
+				}
+				response := httptest.NewRecorder()
+				handler.ServeHTTP(response, request)
+				return response
+			}
+
+			response := serve(nil)
+			assert.Equal(t, http.StatusOK, response.Code)
+			cookies := response.Result().Cookies()
+			if !assert.Len(t, cookies, 1) {
+				return
+			}
+
+			// Dropping the cookie keeps the client on its IP quota.
+			response = serve(nil)
+			assert.Equal(t, http.StatusTooManyRequests, response.Code)
+			unknown := &http.Cookie{Name: cookies[0].Name, Value: "unknown"}
+			response = serve(unknown)
+			assert.Equal(t, http.StatusTooManyRequests, response.Code)
+
+			response = serve(cookies[0])
+			assert.Equal(t, http.StatusOK, response.Code)
+			response = serve(cookies[0])
+			assert.Equal(t, http.StatusTooManyRequests, response.Code)
+		})
+	}
+}
+
+func TestRateLimitFailures(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	response := httptest.NewRecorder()
+	RateLimit(failingLimiter{}, nil)(next).ServeHTTP(response, request)
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	response = httptest.NewRecorder()
+	RateLimit(failingLimiter{}, &RateLimitOptions{FailClosed: true})(
+		next).ServeHTTP(response, request)
+	assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+
+	response = httptest.NewRecorder()
+	RateLimit(failingLimiter{}, &RateLimitOptions{
+		Key:        KeyByHeader("X-Api-Key"),
+		FailClosed: true,
+	})(next).ServeHTTP(response, request)
+	assert.Equal(t, http.StatusOK, response.Code, "empty keys are not limited")
+	assert.Empty(t, response.Header().Get("RateLimit-Limit"))
+}
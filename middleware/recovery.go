@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/candango/httpok"
+	"github.com/candango/httpok/logger"
+)
+
+// PanicReporter receives every recovered panic, for example to forward it to
+// an error tracking service. The stack is captured at recovery time.
+type PanicReporter func(r *http.Request, recovered any, stack []byte)
+
+// RecoveryOptions configures the Recovery middleware.
+type RecoveryOptions struct {
+	// Logger receives the panic value and stack. A nil logger uses the
+	// standard logger.
+	Logger logger.Logger
+	// Renderer writes the 500 response. A nil renderer uses
+	// TextErrorRenderer.
+	Renderer ErrorRenderer
+	// Reporter is an optional hook called after the panic is logged.
+	Reporter PanicReporter
+}
+
+// Recovery creates a middleware that recovers panics raised by the next
+// handler.
+//
+// The panic value and stack are logged and handed to the optional reporter.
+// When the response headers were not sent yet, the configured renderer writes
+// a 500 response; otherwise the partial response is left as is. Panics with
+// http.ErrAbortHandler are propagated so net/http can abort the connection.
+//
+// Place Recovery after Sessioned in a Chain so the session middleware still
+// persists or deletes the session when a handler panics. A nil options value
+// uses the defaults.
+func Recovery(opts *RecoveryOptions) func(http.Handler) http.Handler {
+	var options RecoveryOptions
+	if opts != nil {
+		options = *opts
+	}
+	if options.Logger == nil {
+		options.Logger = &logger.StandardLogger{}
+	}
+	if options.Renderer == nil {
+		options.Renderer = TextErrorRenderer
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			wrapped := &httpok.WrappedWriter{
+				ResponseWriter: w,
+				StatusCode:     http.StatusOK,
+			}
+			defer func() {
+				recovered := recover()
+				if recovered == nil {
+					return
+				}
+				if recovered == http.ErrAbortHandler {
+					panic(recovered)
+				}
+				stack := debug.Stack()
+				options.Logger.Errorf("panic serving %s %s: %v\n%s", r.Method,
+					r.URL.Path, recovered, stack)
+				if options.Reporter != nil {
+					options.Reporter(r, recovered, stack)
+				}
+				if wrapped.HeaderWritten {
+					return
+				}
+				options.Renderer(wrapped, r, http.StatusInternalServerError,
+					fmt.Errorf("panic: %v", recovered))
+			}()
+			next.ServeHTTP(wrapped, r)
+		})
+	}
+}
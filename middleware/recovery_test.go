@@ -0,0 +1,159 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/candango/httpok/session"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *recordingLogger) record(level, format string, v ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, level+": "+fmt.Sprintf(format, v...))
+}
+
+func (l *recordingLogger) Infof(format string, v ...any) {
+	l.record("info", format, v...)
+}
+
+func (l *recordingLogger) Errorf(format string, v ...any) {
+	l.record("error", format, v...)
+}
+
+func (l *recordingLogger) Fatalf(format string, v ...any) {
+	l.record("fatal", format, v...)
+}
+
+func (l *recordingLogger) Printf(format string, v ...any) {
+	l.record("info", format, v...)
+}
+
+func (l *recordingLogger) Warnf(format string, v ...any) {
+	l.record("warn", format, v...)
+}
+
+func (l *recordingLogger) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return strings.Join(l.lines, "\n")
+}
+
+func TestRecoveryRendersInternalServerError(t *testing.T) {
+	log := &recordingLogger{}
+	var reported any
+	handler := Recovery(&RecoveryOptions{
+		Logger:   log,
+		Renderer: ProblemErrorRenderer,
+		Reporter: func(r *http.Request, recovered any, stack []byte) {
+			reported = recovered
+			assert.NotEmpty(t, stack)
+		},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		panic("boom")
+	}))
+
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/panic", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, response.Code)
+	assert.Equal(t, "application/problem+json",
+		response.Header().Get("Content-Type"))
+	problem := map[string]any{}
+	assert.NoError(t, json.Unmarshal(response.Body.Bytes(), &problem))
+	assert.Equal(t, float64(http.StatusInternalServerError), problem["status"])
+	assert.Equal(t, "/panic", problem["instance"])
+	assert.NotContains(t, response.Body.String(), "boom")
+	assert.Equal(t, "boom", reported)
+	assert.Contains(t, log.String(), "panic serving GET /panic: boom")
+	assert.Contains(t, log.String(), "goroutine")
+}
+
+func TestRecoveryKeepsResponseWhenHeadersWereSent(t *testing.T) {
+	log := &recordingLogger{}
+	handler := Recovery(&RecoveryOptions{Logger: log})(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+			_, _ = w.Write([]byte("partial"))
+			panic("late")
+		}))
+
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusAccepted, response.Code)
+	assert.Equal(t, "partial", response.Body.String())
+	assert.Contains(t, log.String(), "late")
+}
+
+func TestRecoveryPropagatesAbortHandler(t *testing.T) {
+	handler := Recovery(&RecoveryOptions{Logger: &recordingLogger{}})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		}))
+
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		handler.ServeHTTP(httptest.NewRecorder(),
+			httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+}
+
+func TestRecoveryLetsSessionedPersist(t *testing.T) {
+	store := newCountingStore()
+	engine := session.NewStoreEngine(store)
+	handler := Chain(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sess, err := session.SessionFromContext(r.Context())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			assert.NoError(t, sess.Set("before", "panic"))
+			panic("boom")
+		}),
+		Sessioned(engine),
+		Recovery(&RecoveryOptions{Logger: &recordingLogger{}}),
+	)
+
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, response.Code)
+	assert.Len(t, response.Result().Cookies(), 1)
+	sets, _ := store.calls()
+	assert.Equal(t, 2, sets)
+}
+
+func TestNegotiatedErrorRenderer(t *testing.T) {
+	tests := []struct {
+		accept      string
+		contentType string
+	}{
+		{"application/problem+json", "application/problem+json"},
+		{"application/json;q=0.9", "application/json"},
+		{"text/html,application/xhtml+xml", "text/html; charset=utf-8"},
+		{"", "text/plain; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.Header.Set("Accept", tt.accept)
+			response := httptest.NewRecorder()
+			NegotiatedErrorRenderer(response, request, http.StatusNotFound, nil)
+			assert.Equal(t, http.StatusNotFound, response.Code)
+			assert.Equal(t, tt.contentType, response.Header().Get("Content-Type"))
+		})
+	}
+}
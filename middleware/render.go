@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// ErrorRenderer writes an error response with the given status code.
+//
+// The err argument carries the internal cause and is meant for logging or
+// development renderers. The built-in renderers never expose it to clients.
+type ErrorRenderer func(w http.ResponseWriter, r *http.Request, status int, err error)
+
+// TextErrorRenderer writes the status text as a plain text response, the same
+// way http.Error does.
+func TextErrorRenderer(w http.ResponseWriter, _ *http.Request, status int,
+	_ error) {
+	http.Error(w, http.StatusText(status), status)
+}
+
+// HTMLErrorRenderer writes a minimal HTML page containing the status code and
+// text.
+func HTMLErrorRenderer(w http.ResponseWriter, _ *http.Request, status int,
+	_ error) {
+	text := html.EscapeString(http.StatusText(status))
+	prepareErrorResponse(w, "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<!DOCTYPE html>\n<html><head><title>%d %s</title></head>"+
+		"<body><h1>%d %s</h1></body></html>\n", status, text, status, text)
+}
+
+// JSONErrorRenderer writes a JSON object with the status code and text.
+func JSONErrorRenderer(w http.ResponseWriter, _ *http.Request, status int,
+	_ error) {
+	prepareErrorResponse(w, "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"status": status,
+		"error":  http.StatusText(status),
+	})
+}
+
+// ProblemErrorRenderer writes an RFC 9457 problem details document using the
+// application/problem+json media type.
+func ProblemErrorRenderer(w http.ResponseWriter, r *http.Request, status int,
+	_ error) {
+	prepareErrorResponse(w, "application/problem+json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"type":     "about:blank",
+		"title":    http.StatusText(status),
+		"status":   status,
+		"instance": r.URL.Path,
+	})
+}
+
+// NegotiatedErrorRenderer selects the problem+json, JSON, HTML or plain text
+// renderer based on the request Accept header.
+func NegotiatedErrorRenderer(w http.ResponseWriter, r *http.Request,
+	status int, err error) {
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, parseErr := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if parseErr != nil {
+			continue
+		}
+		switch mediaType {
+		case "application/problem+json":
+			ProblemErrorRenderer(w, r, status, err)
+			return
+		case "application/json":
+			JSONErrorRenderer(w, r, status, err)
+			return
+		case "text/html":
+			HTMLErrorRenderer(w, r, status, err)
+			return
+		}
+	}
+	TextErrorRenderer(w, r, status, err)
+}
+
+// prepareErrorResponse resets the representation headers a handler may have
+// set before the error and applies contentType.
+func prepareErrorResponse(w http.ResponseWriter, contentType string) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Del("Content-Encoding")
+	h.Set("Content-Type", contentType)
+	h.Set("X-Content-Type-Options", "nosniff")
+}
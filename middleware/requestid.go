@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/candango/httpok"
+	"github.com/candango/httpok/security"
+	"github.com/candango/httpok/tracing"
+)
+
+// RequestIDOptions configures the RequestID middleware.
+type RequestIDOptions struct {
+	// Header is read from the request and set on the response. Empty uses
+	// httpok.RequestIDHeader.
+	Header string
+	// Generator creates IDs for requests without a usable incoming ID. A nil
+	// generator uses 16 random bytes from crypto/rand encoded as hex;
+	// security.NewULID is a sortable alternative.
+	Generator func() string
+	// Validator checks incoming IDs. Invalid IDs are replaced by generated
+	// ones. A nil validator uses httpok.ValidRequestID.
+	Validator func(string) bool
+	// IgnoreIncoming always generates a new ID, which is useful on edge
+	// services that must not trust client supplied IDs.
+	IgnoreIncoming bool
+}
+
+// RequestID creates a middleware that assigns an ID to every request.
+//
+// The ID is taken from the configured header, or derived from the trace ID of
+// a valid W3C traceparent header, or generated. It is validated, set on the
+// response header and stored in the request context, where it can be read
+// with httpok.RequestIDFromContext and propagated to outbound calls with
+// httpok.RequestIDTransport. A nil options value uses the defaults.
+func RequestID(opts *RequestIDOptions) func(http.Handler) http.Handler {
+	var options RequestIDOptions
+	if opts != nil {
+		options = *opts
+	}
+	if options.Header == "" {
+		options.Header = httpok.RequestIDHeader
+	}
+	if options.Generator == nil {
+		options.Generator = func() string { return security.RandomHex(16) }
+	}
+	if options.Validator == nil {
+		options.Validator = httpok.ValidRequestID
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := ""
+			if !options.IgnoreIncoming {
+				id = incomingRequestID(r, options.Header, options.Validator)
+			}
+			if id == "" {
+				id = options.Generator()
+			}
+			w.Header().Set(options.Header, id)
+			ctx := httpok.ContextWithRequestID(r.Context(), id)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// incomingRequestID returns the first valid ID found in the request header or
+// in the traceparent header.
+func incomingRequestID(r *http.Request, header string,
+	valid func(string) bool) string {
+	if id := strings.TrimSpace(r.Header.Get(header)); id != "" && valid(id) {
+		return id
+	}
+	sc, ok := tracing.ParseTraceParent(r.Header.Get(tracing.TraceParentHeader))
+	if id := sc.TraceID.String(); ok && valid(id) {
+		return id
+	}
+	return ""
+}
@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/candango/httpok"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	handler := RequestID(&RequestIDOptions{
+		Generator: func() string { return "generated" },
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = httpok.RequestIDFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		name        string
+		header      string
+		traceparent string
+		expected    string
+	}{
+		{"Generated", "", "", "generated"},
+		{"Incoming", "abc-123", "", "abc-123"},
+		{"Invalid incoming", "bad id\n", "", "generated"},
+		{"Trace parent", "",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			"4bf92f3577b34da6a3ce929d0e0e4736"},
+		{"Header wins over trace parent", "abc-123",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			"abc-123"},
+		{"Zero trace id", "",
+			"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+			"generated"},
+		{"Malformed trace parent", "", "00-XYZ-00f067aa0ba902b7-01",
+			"generated"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				request.Header.Set("X-Request-ID", tt.header)
+			}
+			if tt.traceparent != "" {
+				request.Header.Set("traceparent", tt.traceparent)
+			}
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response, request)
+			assert.Equal(t, tt.expected, seen)
+			assert.Equal(t, tt.expected, response.Header().Get("X-Request-ID"))
+		})
+	}
+}
+
+func TestRequestIDIgnoreIncoming(t *testing.T) {
+	handler := RequestID(&RequestIDOptions{
+		Header:         "X-Correlation-ID",
+		IgnoreIncoming: true,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set("X-Correlation-ID", "client-value")
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+
+	id := response.Header().Get("X-Correlation-ID")
+	assert.NotEqual(t, "client-value", id)
+	assert.Len(t, id, 32)
+}
+
+func TestLoggingIncludesRequestID(t *testing.T) {
+	logger := &recordingLogger{}
+	handler := Chain(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		RequestID(nil),
+		Logging(logger),
+	)
+
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set("X-Request-ID", "req-42")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	assert.Contains(t, logger.String(), "GET 200 / ")
+	assert.Contains(t, logger.String(), " req-42")
+}
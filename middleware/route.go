@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+)
+
+// routeKey is the context key of the route recorded for Metrics and Tracing.
+type routeKey struct{}
+
+// RecordRoute wraps a http.ServeMux so the pattern it matches is visible to
+// Metrics, Tracing and RoutePattern in the middleware around it:
+//
+//	handler := middleware.Chain(middleware.RecordRoute(mux),
+//		middleware.Metrics(registry, nil),
+//		middleware.Sessioned(engine),
+//	)
+//
+// The mux sets the pattern only on the request value it receives, which is
+// not the one seen by outer middleware once any middleware in between calls
+// r.WithContext, as Sessioned, RequestID, ProxyHeaders and SecurityHeaders
+// do. Handlers registered with a Group record their pattern without it.
+func RecordRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer recordRoute(r)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RoutePattern returns the http.ServeMux pattern matched for r, or an empty
+// string when no pattern was matched or recorded.
+func RoutePattern(r *http.Request) string {
+	if r.Pattern != "" {
+		return r.Pattern
+	}
+	if route, ok := r.Context().Value(routeKey{}).(*atomic.Pointer[string]); ok {
+		if pattern := route.Load(); pattern != nil {
+			return *pattern
+		}
+	}
+	return ""
+}
+
+// withRoute returns r with a context holding the route recorded by the mux,
+// reusing the one of an outer middleware.
+func withRoute(r *http.Request) *http.Request {
+	if _, ok := r.Context().Value(routeKey{}).(*atomic.Pointer[string]); ok {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), routeKey{},
+		&atomic.Pointer[string]{}))
+}
+
+// recordRoute stores the pattern the mux matched for r in its context. The
+// holder is atomic because Timeout can still run the handler while the outer
+// middleware reads it.
+func recordRoute(r *http.Request) {
+	if r.Pattern == "" {
+		return
+	}
+	if route, ok := r.Context().Value(routeKey{}).(*atomic.Pointer[string]); ok {
+		pattern := r.Pattern
+		route.Store(&pattern)
+	}
+}
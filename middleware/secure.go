@@ -0,0 +1,272 @@
+package middleware
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ContextCSPNonceValue is the request context key holding the CSP nonce
+	// generated by the SecurityHeaders middleware.
+	ContextCSPNonceValue = "HTTPOKCSPNONCECTXVALUE"
+	// CSPNonceSource is a placeholder source replaced, on every request, with
+	// the 'nonce-...' source of the request nonce.
+	CSPNonceSource = "'nonce'"
+	// maxCSPReportSize bounds the accepted violation report body.
+	maxCSPReportSize = 64 << 10
+)
+
+// CSPDirective is a Content-Security-Policy directive and its sources.
+type CSPDirective struct {
+	Name    string
+	Sources []string
+}
+
+// SecurityHeadersOptions configures the SecurityHeaders middleware. Zero
+// fields omit their header; start from DefaultSecurityHeadersOptions to keep
+// the defaults.
+type SecurityHeadersOptions struct {
+	// HSTSMaxAge is the Strict-Transport-Security max-age. Browsers ignore
+	// the header on plain HTTP responses.
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+	// NoSniff sets X-Content-Type-Options: nosniff.
+	NoSniff bool
+	// ReferrerPolicy is the Referrer-Policy value.
+	ReferrerPolicy string
+	// PermissionsPolicy is the Permissions-Policy value, such as
+	// "camera=(), geolocation=()".
+	PermissionsPolicy string
+	// FrameOptions is the X-Frame-Options value, DENY or SAMEORIGIN.
+	FrameOptions string
+	// CSP lists the Content-Security-Policy directives, in order. Sources
+	// equal to CSPNonceSource are replaced with the request nonce.
+	CSP []CSPDirective
+	// CSPReportURI adds a report-uri directive sending violation reports to
+	// this URI, for example one served by CSPReportHandler.
+	CSPReportURI string
+	// CSPReportOnly sends the policy as
+	// Content-Security-Policy-Report-Only, reporting violations without
+	// enforcing the policy.
+	CSPReportOnly bool
+}
+
+// DefaultSecurityHeadersOptions returns the options used when
+// SecurityHeaders receives nil: one year of HSTS, nosniff,
+// strict-origin-when-cross-origin referrers, DENY framing and a strict
+// nonce-based policy.
+func DefaultSecurityHeadersOptions() *SecurityHeadersOptions {
+	return &SecurityHeadersOptions{
+		HSTSMaxAge:     365 * 24 * time.Hour,
+		NoSniff:        true,
+		ReferrerPolicy: "strict-origin-when-cross-origin",
+		FrameOptions:   "DENY",
+		CSP: []CSPDirective{
+			{Name: "default-src", Sources: []string{"'self'"}},
+			{Name: "script-src", Sources: []string{"'self'", CSPNonceSource}},
+			{Name: "style-src", Sources: []string{"'self'", CSPNonceSource}},
+			{Name: "object-src", Sources: []string{"'none'"}},
+			{Name: "base-uri", Sources: []string{"'self'"}},
+			{Name: "frame-ancestors", Sources: []string{"'none'"}},
+		},
+	}
+}
+
+// SecurityHeaders creates a middleware setting security response headers
+// before the next handler runs, so handlers can still override them.
+//
+// When the policy uses CSPNonceSource, a new random nonce is generated for
+// every request and exposed through CSPNonce, so templates can mark their
+// inline scripts and styles with it.
+func SecurityHeaders(opts *SecurityHeadersOptions) func(http.Handler) http.Handler {
+	if opts == nil {
+		opts = DefaultSecurityHeadersOptions()
+	}
+	options := *opts
+	static := http.Header{}
+	if options.HSTSMaxAge > 0 {
+		value := "max-age=" + strconv.FormatInt(
+			int64(options.HSTSMaxAge/time.Second), 10)
+		if options.HSTSIncludeSubdomains {
+			value += "; includeSubDomains"
+		}
+		if options.HSTSPreload {
+			value += "; preload"
+		}
+		static.Set("Strict-Transport-Security", value)
+	}
+	if options.NoSniff {
+		static.Set("X-Content-Type-Options", "nosniff")
+	}
+	if options.ReferrerPolicy != "" {
+		static.Set("Referrer-Policy", options.ReferrerPolicy)
+	}
+	if options.PermissionsPolicy != "" {
+		static.Set("Permissions-Policy", options.PermissionsPolicy)
+	}
+	if options.FrameOptions != "" {
+		static.Set("X-Frame-Options", options.FrameOptions)
+	}
+
+	cspHeader := "Content-Security-Policy"
+	if options.CSPReportOnly {
+		cspHeader = "Content-Security-Policy-Report-Only"
+	}
+	directives := append([]CSPDirective(nil), options.CSP...)
+	if options.CSPReportURI != "" {
+		directives = append(directives, CSPDirective{
+			Name:    "report-uri",
+			Sources: []string{options.CSPReportURI},
+		})
+	}
+	usesNonce := false
+	for _, directive := range directives {
+		for _, source := range directive.Sources {
+			usesNonce = usesNonce || source == CSPNonceSource
+		}
+	}
+	policy := formatCSP(directives, "")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			for name, values := range static {
+				h[name] = values
+			}
+			if !usesNonce {
+				if policy != "" {
+					h.Set(cspHeader, policy)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			nonce := newCSPNonce()
+			h.Set(cspHeader, formatCSP(directives, nonce))
+			ctx := context.WithValue(r.Context(), ContextCSPNonceValue, nonce)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// CSPNonce returns the CSP nonce of the request, or an empty string when the
+// policy has no nonce.
+func CSPNonce(ctx context.Context) string {
+	nonce, _ := ctx.Value(ContextCSPNonceValue).(string)
+	return nonce
+}
+
+// formatCSP renders directives, replacing CSPNonceSource with nonce.
+func formatCSP(directives []CSPDirective, nonce string) string {
+	parts := make([]string, 0, len(directives))
+	for _, directive := range directives {
+		part := directive.Name
+		for _, source := range directive.Sources {
+			if source == CSPNonceSource {
+				source = "'nonce-" + nonce + "'"
+			}
+			part += " " + source
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "; ")
+}
+
+func newCSPNonce() string {
+	b := make([]byte, 16)
+	_, _ = cryptorand.Read(b)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// CSPReport is a Content-Security-Policy violation report, received either
+// in the legacy report-uri format or from the Reporting API.
+type CSPReport struct {
+	DocumentURI        string `json:"documentURL"`
+	Referrer           string `json:"referrer"`
+	BlockedURI         string `json:"blockedURL"`
+	ViolatedDirective  string `json:"violatedDirective"`
+	EffectiveDirective string `json:"effectiveDirective"`
+	OriginalPolicy     string `json:"originalPolicy"`
+	Disposition        string `json:"disposition"`
+	StatusCode         int    `json:"statusCode"`
+	SourceFile         string `json:"sourceFile"`
+	LineNumber         int    `json:"lineNumber"`
+	ColumnNumber       int    `json:"columnNumber"`
+	Sample             string `json:"sample"`
+}
+
+// legacyCSPReport is the body of an application/csp-report request.
+type legacyCSPReport struct {
+	Report struct {
+		DocumentURI        string `json:"document-uri"`
+		Referrer           string `json:"referrer"`
+		BlockedURI         string `json:"blocked-uri"`
+		ViolatedDirective  string `json:"violated-directive"`
+		EffectiveDirective string `json:"effective-directive"`
+		OriginalPolicy     string `json:"original-policy"`
+		Disposition        string `json:"disposition"`
+		StatusCode         int    `json:"status-code"`
+		SourceFile         string `json:"source-file"`
+		LineNumber         int    `json:"line-number"`
+		ColumnNumber       int    `json:"column-number"`
+		Sample             string `json:"script-sample"`
+	} `json:"csp-report"`
+}
+
+// CSPReportHandler returns a handler collecting CSP violation reports posted
+// by browsers. It accepts the legacy application/csp-report format and
+// csp-violation entries of the application/reports+json format, calls
+// collect for each report and answers 204 No Content.
+func CSPReportHandler(collect func(r *http.Request,
+	report CSPReport)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed),
+				http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxCSPReportSize+1))
+		if err != nil || len(body) > maxCSPReportSize {
+			http.Error(w, http.StatusText(http.StatusBadRequest),
+				http.StatusBadRequest)
+			return
+		}
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		var reports []CSPReport
+		switch mediaType {
+		case "application/reports+json":
+			var entries []struct {
+				Type string    `json:"type"`
+				Body CSPReport `json:"body"`
+			}
+			err = json.Unmarshal(body, &entries)
+			for _, entry := range entries {
+				if entry.Type == "csp-violation" {
+					reports = append(reports, entry.Body)
+				}
+			}
+		default:
+			var legacy legacyCSPReport
+			err = json.Unmarshal(body, &legacy)
+			reports = append(reports, CSPReport(legacy.Report))
+		}
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest),
+				http.StatusBadRequest)
+			return
+		}
+		for _, report := range reports {
+			collect(r, report)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecurityHeadersDefaults(t *testing.T) {
+	var nonces []string
+	handler := SecurityHeaders(nil)(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			nonces = append(nonces, CSPNonce(r.Context()))
+		}))
+
+	var policies []string
+	for range 2 {
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/",
+			nil))
+		h := response.Header()
+		assert.Equal(t, "max-age=31536000", h.Get("Strict-Transport-Security"))
+		assert.Equal(t, "nosniff", h.Get("X-Content-Type-Options"))
+		assert.Equal(t, "strict-origin-when-cross-origin",
+			h.Get("Referrer-Policy"))
+		assert.Equal(t, "DENY", h.Get("X-Frame-Options"))
+		assert.Empty(t, h.Get("Permissions-Policy"))
+		policies = append(policies, h.Get("Content-Security-Policy"))
+	}
+
+	assert.Len(t, nonces, 2)
+	assert.NotEmpty(t, nonces[0])
+	assert.NotEqual(t, nonces[0], nonces[1])
+	assert.Equal(t, "default-src 'self'; script-src 'self' 'nonce-"+nonces[0]+
+		"'; style-src 'self' 'nonce-"+nonces[0]+"'; object-src 'none'; "+
+		"base-uri 'self'; frame-ancestors 'none'", policies[0])
+	assert.Contains(t, policies[1], "'nonce-"+nonces[1]+"'")
+}
+
+func TestSecurityHeadersOptions(t *testing.T) {
+	var nonce string
+	handler := SecurityHeaders(&SecurityHeadersOptions{
+		HSTSMaxAge:            time.Hour,
+		HSTSIncludeSubdomains: true,
+		HSTSPreload:           true,
+		PermissionsPolicy:     "camera=(), geolocation=()",
+		CSP: []CSPDirective{
+			{Name: "default-src", Sources: []string{"'none'"}},
+			{Name: "img-src", Sources: []string{"'self'", "data:"}},
+		},
+		CSPReportURI:  "/csp-reports",
+		CSPReportOnly: true,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce = CSPNonce(r.Context())
+		w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+	}))
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	h := response.Header()
+	assert.Empty(t, nonce)
+	assert.Equal(t, "max-age=3600; includeSubDomains; preload",
+		h.Get("Strict-Transport-Security"))
+	assert.Equal(t, "camera=(), geolocation=()", h.Get("Permissions-Policy"))
+	assert.Equal(t, "SAMEORIGIN", h.Get("X-Frame-Options"),
+		"handlers can override headers")
+	assert.Empty(t, h.Get("X-Content-Type-Options"))
+	assert.Empty(t, h.Get("Content-Security-Policy"))
+	assert.Equal(t, "default-src 'none'; img-src 'self' data:; "+
+		"report-uri /csp-reports", h.Get("Content-Security-Policy-Report-Only"))
+}
+
+func TestCSPReportHandler(t *testing.T) {
+	var reports []CSPReport
+	handler := CSPReportHandler(func(r *http.Request, report CSPReport) {
+		reports = append(reports, report)
+	})
+	post := func(contentType, body string) int {
+		request := httptest.NewRequest(http.MethodPost, "/csp-reports",
+			strings.NewReader(body))
+		request.Header.Set("Content-Type", contentType)
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		return response.Code
+	}
+
+	assert.Equal(t, http.StatusNoContent, post("application/csp-report", `{
+		"csp-report": {
+			"document-uri": "https://example.com/page",
+			"blocked-uri": "https://evil.test/x.js",
+			"violated-directive": "script-src",
+			"effective-directive": "script-src",
+			"status-code": 200,
+			"line-number": 7
+		}
+	}`))
+	assert.Equal(t, http.StatusNoContent, post("application/reports+json", `[
+		{"type": "csp-violation", "body": {
+			"documentURL": "https://example.com/other",
+			"blockedURL": "inline",
+			"effectiveDirective": "style-src-elem",
+			"disposition": "report"
+		}},
+		{"type": "deprecation", "body": {}}
+	]`))
+	assert.Equal(t, http.StatusBadRequest, post("application/csp-report", "{"))
+	assert.Equal(t, http.StatusBadRequest, post("application/csp-report",
+		`{"csp-report": {"sample": "`+strings.Repeat("x", 70<<10)+`"}}`))
+
+	assert.Len(t, reports, 2)
+	assert.Equal(t, CSPReport{
+		DocumentURI:        "https://example.com/page",
+		BlockedURI:         "https://evil.test/x.js",
+		ViolatedDirective:  "script-src",
+		EffectiveDirective: "script-src",
+		StatusCode:         200,
+		LineNumber:         7,
+	}, reports[0])
+	assert.Equal(t, CSPReport{
+		DocumentURI:        "https://example.com/other",
+		BlockedURI:         "inline",
+		EffectiveDirective: "style-src-elem",
+		Disposition:        "report",
+	}, reports[1])
+
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet,
+		"/csp-reports", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, response.Code)
+}
@@ -5,8 +5,12 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/candango/httpok"
 	"github.com/candango/httpok/security"
 	"github.com/candango/httpok/session"
 )
@@ -122,47 +126,297 @@ func cookieOptions(properties *session.EngineProperties) session.CookieOptions {
 // session IDs. Missing, invalid, expired, or unknown session IDs are replaced
 // with new sessions. The current session is added to the request context before
 // the next handler runs and is persisted after the handler returns.
+//
+// The Set-Cookie header is decided just before the response headers are
+// written, on the first WriteHeader, Write or Flush, or when the handler
+// returns without writing. It reflects what the handler did with the
+// session: new and regenerated sessions get a cookie for their ID, destroyed
+// sessions get an expired cookie, and cookies signed with a key other than
+// the active one are signed again with the active key. After a
+// Session.Regenerate the data is saved under the new ID and the old ID is
+// deleted from the store.
 func Sessioned(e session.Engine) func(http.Handler) http.Handler {
+	return sessioned(e, false)
+}
+
+// LazySessioned returns middleware managing sessions like Sessioned, except
+// that the session is loaded from the store only when the handler first
+// calls session.SessionFromContext, and a new session is stored and sent as
+// a cookie only once data is written to it. Requests that never use their
+// session, such as health checks, bots and static assets, do not contact the
+// store.
+//
+// Data written to a new session after the response headers are sent is
+// discarded, as the client cannot receive its cookie.
+func LazySessioned(e session.Engine) func(http.Handler) http.Handler {
+	return sessioned(e, true)
+}
+
+func sessioned(e session.Engine, lazy bool) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			ctxEngine := context.WithValue(r.Context(), session.ContextEngValue, e)
-			id, ok := sessionIDFromRequest(e, r)
-			if ok {
-				exists, err := e.SessionExists(ctxEngine, id)
+			sw := &sessionWriter{
+				ResponseWriter: w,
+				request:        r,
+				engine:         e,
+				ctx:            ctxEngine,
+				lazy:           lazy,
+			}
+			_, err := r.Cookie(e.Properties().Name)
+			sw.hadCookie = err == nil
+
+			var value any = sw
+			if !lazy {
+				s, err := sw.LoadSession()
 				if err != nil {
 					w.WriteHeader(http.StatusInternalServerError)
 					return
 				}
-				ok = exists
-			}
-			if !ok {
-				id = e.NewId(r.Context())
-				setSessionCookie(w, e, id)
+				value = s
 			}
+			ctxSess := context.WithValue(ctxEngine, session.ContextSessValue,
+				value)
+			ctxSess = context.WithValue(ctxSess, sessionWriterKey{}, sw)
+			next.ServeHTTP(sw, r.WithContext(ctxSess))
+			sw.finish()
+		})
+	}
+}
 
-			s, err := e.GetSession(ctxEngine, id)
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
-			ctxSess := context.WithValue(ctxEngine, session.ContextSessValue, &s)
-			next.ServeHTTP(w, r.WithContext(ctxSess))
-			if s.Destroyed {
-				if err := e.DeleteSession(ctxEngine, s.Id); err != nil {
-					log.Printf("failed to delete session %s: %v", s.Id, err)
-				}
-				return
-			}
-			if !s.Changed {
-				return
-			}
-			if err := e.SaveSession(ctxEngine, s.Id, s); err != nil {
-				log.Printf("failed to save session %s: %v", s.Id, err)
+// sessionWriterKey is the context key of the sessionWriter of a request.
+type sessionWriterKey struct{}
+
+// clientSessionId returns the ID of the existing session the client sent in
+// its session cookie, loading the session if needed. It returns an empty
+// string outside Sessioned and when the request has no valid cookie for a
+// stored session.
+func clientSessionId(r *http.Request) string {
+	sw, ok := r.Context().Value(sessionWriterKey{}).(*sessionWriter)
+	if !ok {
+		return ""
+	}
+	if _, err := sw.LoadSession(); err != nil {
+		return ""
+	}
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	return sw.cookieId
+}
+
+// sessionIds returns the distinct IDs a session had during a request.
+func sessionIds(original, current string) []string {
+	if original == current {
+		return []string{original}
+	}
+	return []string{original, current}
+}
+
+// sessionWriter loads the session of a request and defers its Set-Cookie
+// header until the response headers are about to be written, so it reflects
+// the final session state.
+type sessionWriter struct {
+	http.ResponseWriter
+	request *http.Request
+	engine  session.Engine
+	ctx     context.Context
+	lazy    bool
+	mu      sync.Mutex
+	session *session.Session
+	loadErr error
+	// id is the session ID when it was loaded.
+	id string
+	// stored reports whether id has an entry in the store.
+	stored bool
+	// cookieId is the session ID the client holds, empty when it has no
+	// valid session cookie.
+	cookieId string
+	// hadCookie reports whether the request carried a session cookie, even
+	// an invalid one.
+	hadCookie bool
+	// resign reports whether the request cookie was signed with a key other
+	// than the active one.
+	resign bool
+	sent   bool
+}
+
+// LoadSession implements session.SessionLoader, loading the session on the
+// first call. Sessioned calls it before the handler runs.
+func (w *sessionWriter) LoadSession() (*session.Session, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.session != nil || w.loadErr != nil {
+		return w.session, w.loadErr
+	}
+	e, r := w.engine, w.request
+	id, ok := sessionIDFromRequest(e, r)
+	var found *session.Session
+	if f, isFinder := e.(session.SessionFinder); ok && isFinder {
+		// A SessionFinder loads an existing session in one call.
+		s, exists, err := f.FindSession(w.ctx, id)
+		if err != nil {
+			w.loadErr = err
+			return nil, err
+		}
+		ok = exists
+		if exists {
+			found = &s
+		}
+	} else if ok {
+		exists, err := e.SessionExists(w.ctx, id)
+		if err != nil {
+			w.loadErr = err
+			return nil, err
+		}
+		ok = exists
+	}
+	if ok {
+		w.cookieId = id
+		w.resign = needsResign(e, r)
+	} else {
+		id = e.NewId(r.Context())
+	}
+	w.id = id
+	if found != nil {
+		w.session = found
+		w.stored = true
+		return w.session, nil
+	}
+	if w.lazy && !ok {
+		// New lazy sessions stay in memory until data is written.
+		w.session = &session.Session{Id: id, Ctx: w.ctx, Data: map[string]any{}}
+		return w.session, nil
+	}
+	s, err := e.GetSession(w.ctx, id)
+	if err != nil {
+		w.loadErr = err
+		return nil, err
+	}
+	w.session = &s
+	w.stored = true
+	return w.session, nil
+}
+
+// finish sends the cookie if the handler did not write, then persists,
+// moves or deletes the session in the store.
+func (w *sessionWriter) finish() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.sendCookie()
+	s, e, r := w.session, w.engine, w.request
+	if s == nil {
+		return
+	}
+	if s.Destroyed {
+		if !w.stored && s.Id == w.id {
+			return
+		}
+		for _, destroyed := range sessionIds(w.id, s.Id) {
+			if err := e.DeleteSession(w.ctx, destroyed); err != nil {
+				logf(r, "failed to delete session %s: %v", destroyed, err)
 			}
-		})
+		}
+		return
+	}
+	if s.Id != w.id && s.Id != w.cookieId {
+		// The client never got the regenerated ID, so keep the original one
+		// instead of moving the data to an ID no request will send.
+		logf(r, "session %s regenerated after the response headers were "+
+			"written, keeping its ID", w.id)
+		s.Id = w.id
+	}
+	dirty := s.Dirty()
+	if w.cookieId != s.Id && dirty {
+		logf(r, "session %s changed after the response headers were "+
+			"written", s.Id)
+		if !w.stored && s.Id == w.id {
+			return
+		}
+	}
+	if !dirty {
+		return
+	}
+	if err := e.SaveSession(w.ctx, s.Id, *s); err != nil {
+		logf(r, "failed to save session %s: %v", s.Id, err)
+		return
+	}
+	if s.Id != w.id && w.stored {
+		if err := e.DeleteSession(w.ctx, w.id); err != nil {
+			logf(r, "failed to delete regenerated session %s: %v", w.id, err)
+		}
+	}
+}
+
+// sendCookie sets the session cookie for the current session state, once.
+// A session that was never loaded keeps the client cookie, and a new lazy
+// session gets a cookie only once it has changed.
+func (w *sessionWriter) sendCookie() {
+	if w.sent {
+		return
+	}
+	w.sent = true
+	s := w.session
+	switch {
+	case s == nil:
+	case s.Destroyed:
+		if w.hadCookie {
+			writeSessionCookie(w.ResponseWriter, w.request, w.engine,
+				expiredSessionCookie(w.engine))
+		}
+		w.cookieId = ""
+	case !w.stored && s.Id == w.id && !s.Dirty():
+	case s.Id != w.cookieId || w.resign:
+		setSessionCookie(w.ResponseWriter, w.request, w.engine, s.Id)
+		w.cookieId = s.Id
 	}
 }
 
+// lockedSendCookie calls sendCookie holding the writer lock.
+func (w *sessionWriter) lockedSendCookie() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.sendCookie()
+}
+
+// WriteHeader sends the session cookie before the status. Informational
+// responses other than 101 Switching Protocols leave it pending.
+func (w *sessionWriter) WriteHeader(code int) {
+	if code >= 200 || code == http.StatusSwitchingProtocols {
+		w.lockedSendCookie()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write sends the session cookie before the body.
+func (w *sessionWriter) Write(b []byte) (int, error) {
+	w.lockedSendCookie()
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends the session cookie, then flushes the underlying writer.
+func (w *sessionWriter) Flush() {
+	w.lockedSendCookie()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter, allowing
+// http.ResponseController to reach optional interfaces it implements.
+func (w *sessionWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// logf logs a session middleware message, prefixed with the request ID when
+// the RequestID middleware stored one in the request context.
+func logf(r *http.Request, format string, v ...any) {
+	if id := httpok.RequestIDFromContext(r.Context()); id != "" {
+		format = "request %s: " + format
+		v = append([]any{id}, v...)
+	}
+	log.Printf(format, v...)
+}
+
 // sessionIDFromRequest extracts and validates the configured session cookie
 // from r.
 func sessionIDFromRequest(e session.Engine, r *http.Request) (string, bool) {
@@ -173,7 +427,57 @@ func sessionIDFromRequest(e session.Engine, r *http.Request) (string, bool) {
 	return sessionIDFromCookie(e, cookie.Value)
 }
 
-// setSessionCookie writes a new session cookie to w.
-func setSessionCookie(w http.ResponseWriter, e session.Engine, id string) {
-	http.SetCookie(w, sessionCookie(e, id))
+// setSessionCookie writes the session cookie for id to w.
+func setSessionCookie(w http.ResponseWriter, r *http.Request, e session.Engine,
+	id string) {
+	writeSessionCookie(w, r, e, sessionCookie(e, id))
+}
+
+// expiredSessionCookie returns a session cookie telling the client to
+// remove its session cookie.
+func expiredSessionCookie(e session.Engine) *http.Cookie {
+	cookie := newCookie(e.Properties().Name, "", 0)
+	cookie.MaxAge = -1
+	options := cookieOptions(e.Properties())
+	cookie.HttpOnly = options.HTTPOnly
+	cookie.Secure = options.Secure
+	cookie.SameSite = options.SameSite
+	return cookie
+}
+
+// writeSessionCookie adds cookie to w, replacing a session cookie set
+// earlier in the same response and marking it Secure for HTTPS requests when
+// AutoSecure is enabled.
+func writeSessionCookie(w http.ResponseWriter, r *http.Request,
+	e session.Engine, cookie *http.Cookie) {
+	if cookieOptions(e.Properties()).AutoSecure &&
+		httpok.RequestScheme(r) == "https" {
+		cookie.Secure = true
+	}
+	header := w.Header()
+	prefix := cookie.Name + "="
+	header["Set-Cookie"] = slices.DeleteFunc(header["Set-Cookie"],
+		func(value string) bool { return strings.HasPrefix(value, prefix) })
+	http.SetCookie(w, cookie)
+}
+
+// needsResign reports whether the valid session cookie of r was signed with
+// a key other than the active one, so it should be signed again.
+func needsResign(e session.Engine, r *http.Request) bool {
+	properties := e.Properties()
+	keys := cookieSecrets(properties)
+	if len(keys) < 2 {
+		return false
+	}
+	version, secret, ok := activeCookieSecret(properties, keys)
+	if !ok {
+		return false
+	}
+	cookie, err := r.Cookie(properties.Name)
+	if err != nil {
+		return false
+	}
+	_, ok = security.DecodeSignedValueWithKeys(map[int][]byte{version: secret},
+		properties.Name, cookie.Value, properties.CookieMaxAge, time.Now())
+	return !ok
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -19,6 +20,7 @@ type countingStore struct {
 	mu          sync.Mutex
 	setCalls    int
 	deleteCalls int
+	readCalls   int
 }
 
 func newCountingStore() *countingStore {
@@ -39,6 +41,52 @@ func (s *countingStore) Delete(ctx context.Context, id string) error {
 	return s.MemoryStore.Delete(ctx, id)
 }
 
+func (s *countingStore) Exists(ctx context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	s.readCalls++
+	s.mu.Unlock()
+	return s.MemoryStore.Exists(ctx, id)
+}
+
+func (s *countingStore) Get(ctx context.Context, id string) ([]byte, error) {
+	s.mu.Lock()
+	s.readCalls++
+	s.mu.Unlock()
+	return s.MemoryStore.Get(ctx, id)
+}
+
+func (s *countingStore) GetAndTouch(ctx context.Context, id string) ([]byte,
+	bool, error) {
+	s.mu.Lock()
+	s.readCalls++
+	s.mu.Unlock()
+	return s.MemoryStore.GetAndTouch(ctx, id)
+}
+
+func (s *countingStore) GetVersioned(ctx context.Context, id string) ([]byte,
+	uint64, bool, error) {
+	s.mu.Lock()
+	s.readCalls++
+	s.mu.Unlock()
+	return s.MemoryStore.GetVersioned(ctx, id)
+}
+
+func (s *countingStore) CompareAndSet(ctx context.Context, id string,
+	value []byte, version uint64) (uint64, error) {
+	s.mu.Lock()
+	s.setCalls++
+	s.mu.Unlock()
+	return s.MemoryStore.CompareAndSet(ctx, id, value, version)
+}
+
+// reads returns the number of Exists, Get, GetAndTouch and GetVersioned
+// calls.
+func (s *countingStore) reads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readCalls
+}
+
 func (s *countingStore) calls() (int, int) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -124,7 +172,7 @@ func TestSessionedSignsAndVerifiesSessionCookies(t *testing.T) {
 func TestSessionedPersistsOnlyChangedSessions(t *testing.T) {
 	store := newCountingStore()
 	engine := session.NewStoreEngine(store)
-	mutate := false
+	mutate, nested := false, false
 	handler := Sessioned(engine)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		sess, err := session.SessionFromContext(r.Context())
 		if err != nil {
@@ -133,6 +181,11 @@ func TestSessionedPersistsOnlyChangedSessions(t *testing.T) {
 		}
 		if mutate {
 			assert.NoError(t, sess.Set("value", "changed"))
+			assert.NoError(t, sess.Set("prefs",
+				map[string]any{"theme": "light"}))
+		}
+		if nested {
+			sess.Data["prefs"].(map[string]any)["theme"] = "dark"
 		}
 	}))
 
@@ -161,6 +214,19 @@ func TestSessionedPersistsOnlyChangedSessions(t *testing.T) {
 		sets, deletes = store.calls()
 		assert.Equal(t, 2, sets)
 		assert.Equal(t, 0, deletes)
+
+		mutate, nested = false, true
+		fourthRequest := httptest.NewRequest(http.MethodGet, "/", nil)
+		fourthRequest.AddCookie(firstCookies[0])
+		handler.ServeHTTP(httptest.NewRecorder(), fourthRequest)
+		sets, _ = store.calls()
+		assert.Equal(t, 3, sets, "nested changes without Set are saved")
+		sess, ok, err := engine.FindSession(context.Background(),
+			firstCookies[0].Value)
+		if assert.NoError(t, err) && assert.True(t, ok) {
+			assert.Equal(t, "dark",
+				sess.Data["prefs"].(map[string]any)["theme"])
+		}
 	}
 }
 
@@ -249,6 +315,313 @@ func TestSessionedDeletesDestroyedSessions(t *testing.T) {
 	assert.Len(t, nextResponse.Result().Cookies(), 1)
 }
 
+func TestSessionedRegeneratesSessions(t *testing.T) {
+	store := newCountingStore()
+	engine := session.NewStoreEngine(store,
+		session.WithProperties(&session.EngineProperties{
+			CookieSecret: []byte("secret"),
+		}))
+	action := ""
+	handler := Sessioned(engine)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sess, err := session.SessionFromContext(r.Context())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		switch action {
+		case "set":
+			assert.NoError(t, sess.Set("cart", "3 items"))
+		case "regenerate":
+			assert.NoError(t, sess.Regenerate())
+		case "regenerate-empty":
+			assert.NoError(t, sess.RegenerateEmpty())
+		case "regenerate-after-write":
+			_, _ = w.Write([]byte("written"))
+			assert.NoError(t, sess.Regenerate())
+			assert.NoError(t, sess.Set("cart", "4 items"))
+			return
+		}
+		value, _ := sess.Get("cart")
+		if value != nil {
+			_, _ = w.Write([]byte(value.(string)))
+		}
+	}))
+
+	serve := func(cookie *http.Cookie) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		if cookie != nil {
+			request.AddCookie(cookie)
+		}
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		return response
+	}
+	idOf := func(cookie *http.Cookie) string {
+		id, ok := sessionIDFromCookie(engine, cookie.Value)
+		assert.True(t, ok)
+		return id
+	}
+	exists := func(id string) bool {
+		ok, err := store.Exists(context.Background(), id)
+		assert.NoError(t, err)
+		return ok
+	}
+
+	action = "set"
+	first := serve(nil).Result().Cookies()[0]
+	firstId := idOf(first)
+
+	action = "regenerate"
+	response := serve(first)
+	assert.Equal(t, "3 items", response.Body.String(),
+		"the body is written right after regenerating")
+	cookies := response.Result().Cookies()
+	if assert.Len(t, cookies, 1) {
+		secondId := idOf(cookies[0])
+		assert.NotEqual(t, firstId, secondId)
+		assert.False(t, exists(firstId), "the old entry is deleted")
+		assert.True(t, exists(secondId))
+
+		action = ""
+		assert.Equal(t, "3 items", serve(cookies[0]).Body.String())
+		response = serve(first)
+		assert.Empty(t, response.Body.String(), "the old ID is not accepted")
+		assert.NotEqual(t, firstId, idOf(response.Result().Cookies()[0]))
+
+		action = "regenerate-empty"
+		response = serve(cookies[0])
+		assert.Empty(t, response.Body.String())
+		if assert.Len(t, response.Result().Cookies(), 1) {
+			thirdId := idOf(response.Result().Cookies()[0])
+			assert.False(t, exists(secondId))
+			action = ""
+			assert.Empty(t, serve(response.Result().Cookies()[0]).Body.String())
+			assert.True(t, exists(thirdId))
+		}
+	}
+
+	action = "regenerate"
+	response = serve(nil)
+	assert.Len(t, response.Header().Values("Set-Cookie"), 1,
+		"a new session sends only the regenerated cookie")
+	action = "set"
+	cookie := serve(nil).Result().Cookies()[0]
+	action = "regenerate-after-write"
+	response = serve(cookie)
+	assert.Empty(t, response.Result().Cookies(),
+		"the cookie cannot change after the headers were written")
+	assert.True(t, exists(idOf(cookie)), "the original ID is kept")
+	action = ""
+	assert.Equal(t, "4 items", serve(cookie).Body.String())
+}
+
+func TestSessionedDefersSessionCookie(t *testing.T) {
+	version := 1
+	properties := &session.EngineProperties{
+		CookieSecrets:    map[int][]byte{1: []byte("old"), 2: []byte("new")},
+		CookieKeyVersion: &version,
+	}
+	engine := session.NewStoreEngine(session.NewMemoryStore(),
+		session.WithProperties(properties))
+	action := ""
+	handler := Sessioned(engine)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sess, err := session.SessionFromContext(r.Context())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		switch action {
+		case "write":
+			_, _ = w.Write([]byte("body"))
+		case "destroy":
+			assert.NoError(t, sess.Destroy())
+		case "destroy-after-write":
+			w.WriteHeader(http.StatusNoContent)
+			assert.NoError(t, sess.Destroy())
+		}
+	}))
+	serve := func(cookie *http.Cookie) *http.Response {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		if cookie != nil {
+			request.AddCookie(cookie)
+		}
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		return response.Result()
+	}
+
+	action = "write"
+	cookies := serve(nil).Cookies()
+	if !assert.Len(t, cookies, 1, "new sessions get a cookie before the body") {
+		return
+	}
+	cookie := cookies[0]
+	assert.True(t, strings.HasPrefix(cookie.Value, "2|1:1|"),
+		"signed with key version 1")
+
+	action = ""
+	assert.Empty(t, serve(cookie).Cookies(), "valid cookies are not resent")
+
+	version = 2
+	engine.Properties().CookieKeyVersion = &version
+	cookies = serve(cookie).Cookies()
+	if assert.Len(t, cookies, 1, "cookies are signed with the new key") {
+		assert.True(t, strings.HasPrefix(cookies[0].Value, "2|1:2|"))
+		id, _ := sessionIDFromCookie(engine, cookie.Value)
+		resigned, _ := sessionIDFromCookie(engine, cookies[0].Value)
+		assert.Equal(t, id, resigned)
+		cookie = cookies[0]
+	}
+	assert.Empty(t, serve(cookie).Cookies())
+
+	action = "destroy"
+	cookies = serve(cookie).Cookies()
+	if assert.Len(t, cookies, 1) {
+		assert.Equal(t, -1, cookies[0].MaxAge)
+		assert.Empty(t, cookies[0].Value)
+		assert.True(t, cookies[0].HttpOnly)
+	}
+	assert.Empty(t, serve(nil).Cookies(),
+		"sessions created and destroyed in one request send no cookie")
+
+	action = "write"
+	cookie = serve(nil).Cookies()[0]
+	action = "destroy-after-write"
+	assert.Empty(t, serve(cookie).Cookies(),
+		"the cookie cannot change after the headers are written")
+	action = ""
+	cookies = serve(cookie).Cookies()
+	if assert.Len(t, cookies, 1, "the destroyed session is still deleted") {
+		assert.NotEqual(t, cookie.Value, cookies[0].Value)
+	}
+}
+
+func TestLazySessioned(t *testing.T) {
+	store := newCountingStore()
+	engine := session.NewStoreEngine(store)
+	handler := LazySessioned(engine)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/static":
+			_, _ = w.Write([]byte("asset"))
+			return
+		case "/late":
+			_, _ = w.Write([]byte("body"))
+		}
+		sess, err := session.SessionFromContext(r.Context())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		switch r.URL.Path {
+		case "/cart", "/late":
+			assert.NoError(t, sess.Set("cart", "1 item"))
+		case "/logout":
+			assert.NoError(t, sess.Destroy())
+		}
+		if value, _ := sess.Get("cart"); value != nil {
+			_, _ = w.Write([]byte(value.(string)))
+		}
+	}))
+	serve := func(path string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		if cookie != nil {
+			request.AddCookie(cookie)
+		}
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		return response
+	}
+	assertCalls := func(wantSets, wantDeletes int, msg string) {
+		t.Helper()
+		sets, deletes := store.calls()
+		assert.Equal(t, wantSets, sets, msg)
+		assert.Equal(t, wantDeletes, deletes, msg)
+	}
+
+	response := serve("/static", nil)
+	assert.Empty(t, response.Result().Cookies())
+	assert.Zero(t, store.reads(), "unused sessions do not contact the store")
+	assertCalls(0, 0, "unused sessions")
+
+	response = serve("/browse", nil)
+	assert.Empty(t, response.Result().Cookies())
+	assert.Zero(t, store.reads(), "new sessions are not read from the store")
+	assertCalls(0, 0, "unchanged new sessions are not stored")
+
+	response = serve("/late", nil)
+	assert.Empty(t, response.Result().Cookies())
+	assertCalls(0, 0, "changes after the headers were sent are discarded")
+
+	response = serve("/cart", nil)
+	cookies := response.Result().Cookies()
+	if !assert.Len(t, cookies, 1) {
+		return
+	}
+	assertCalls(1, 0, "changed new sessions are stored")
+	exists, err := store.Exists(context.Background(), cookies[0].Value)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	reads := store.reads()
+	response = serve("/static", cookies[0])
+	assert.Equal(t, reads, store.reads())
+	assert.Empty(t, response.Result().Cookies())
+
+	response = serve("/browse", cookies[0])
+	assert.Equal(t, "1 item", response.Body.String())
+	assert.Empty(t, response.Result().Cookies())
+	assert.Equal(t, reads+1, store.reads(),
+		"existing sessions load with a single store call")
+	assertCalls(1, 0, "unchanged sessions are not saved")
+
+	response = serve("/logout", cookies[0])
+	if assert.Len(t, response.Result().Cookies(), 1) {
+		assert.Equal(t, -1, response.Result().Cookies()[0].MaxAge)
+	}
+	assertCalls(1, 1, "destroyed sessions are deleted")
+}
+
+func TestSessionedFlashes(t *testing.T) {
+	for name, store := range map[string]session.Store{
+		"MemoryStore": session.NewMemoryStore(),
+		"FileStore":   &session.FileStore{Dir: t.TempDir()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			engine := session.NewStoreEngine(store)
+			assert.NoError(t, engine.Start(context.Background()))
+			defer engine.Stop(context.Background())
+			mux := http.NewServeMux()
+			mux.HandleFunc("POST /items", func(w http.ResponseWriter, r *http.Request) {
+				assert.NoError(t, session.AddFlash(r.Context(), "info", "Item saved"))
+				http.Redirect(w, r, "/items", http.StatusSeeOther)
+			})
+			mux.HandleFunc("GET /items", func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(session.FlashesHTML(r.Context(), "info")))
+			})
+			handler := Sessioned(engine)(mux)
+
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response,
+				httptest.NewRequest(http.MethodPost, "/items", nil))
+			assert.Equal(t, http.StatusSeeOther, response.Code)
+			cookies := response.Result().Cookies()
+			if !assert.Len(t, cookies, 1) {
+				return
+			}
+			get := func() string {
+				request := httptest.NewRequest(http.MethodGet, "/items", nil)
+				request.AddCookie(cookies[0])
+				response := httptest.NewRecorder()
+				handler.ServeHTTP(response, request)
+				return response.Body.String()
+			}
+			assert.Equal(t, `<ul class="flashes flashes-info">`+
+				`<li>Item saved</li></ul>`, get(), "flashes survive the redirect")
+			assert.Empty(t, get(), "flashes disappear after display")
+		})
+	}
+}
+
 func TestSessionMiddlewareServer(t *testing.T) {
 	plain := NewPlainServeMux()
 
@@ -0,0 +1,152 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TimeoutOptions configures the Timeout middleware.
+type TimeoutOptions struct {
+	// RouteTimeout returns the timeout for r, overriding the default. It
+	// returns zero to use the default and a negative value to disable the
+	// timeout.
+	RouteTimeout func(r *http.Request) time.Duration
+	// Exempt reports whether r runs without a timeout, in addition to the
+	// streaming and upgrade requests that are always exempt.
+	Exempt func(r *http.Request) bool
+	// Status is the status code sent on timeout, either 503 Service
+	// Unavailable or 504 Gateway Timeout. Zero uses 503.
+	Status int
+	// Renderer writes the timeout response. A nil renderer uses
+	// TextErrorRenderer.
+	Renderer ErrorRenderer
+}
+
+// Timeout creates a middleware running the next handler with a deadline of
+// timeout on the request context.
+//
+// Like http.TimeoutHandler, the handler runs in its own goroutine and writes
+// to a buffer, which is copied to the client once the handler returns. When
+// the deadline passes first, the configured status is rendered and later
+// writes by the handler fail with http.ErrHandlerTimeout, so the two never
+// race on the response writer. Handlers should watch the context to stop
+// early. A panic in the handler is re-raised in the serving goroutine, so
+// Recovery placed before Timeout still handles it.
+//
+// Streaming and upgrade requests, those accepting text/event-stream or
+// carrying an Upgrade header, are passed through without a deadline or
+// buffering. Place Timeout before Sessioned so session loading and saving run
+// under the deadline; an expired deadline then stops session store I/O.
+func Timeout(timeout time.Duration,
+	opts *TimeoutOptions) func(http.Handler) http.Handler {
+	var options TimeoutOptions
+	if opts != nil {
+		options = *opts
+	}
+	if options.Status == 0 {
+		options.Status = http.StatusServiceUnavailable
+	}
+	if options.Renderer == nil {
+		options.Renderer = TextErrorRenderer
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			d := timeout
+			if options.RouteTimeout != nil {
+				if route := options.RouteTimeout(r); route != 0 {
+					d = route
+				}
+			}
+			if d <= 0 || streamingRequest(r) ||
+				(options.Exempt != nil && options.Exempt(r)) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			tw := &timeoutWriter{header: make(http.Header)}
+			done := make(chan struct{})
+			panicked := make(chan any, 1)
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						panicked <- p
+					}
+				}()
+				next.ServeHTTP(tw, r.WithContext(ctx))
+				close(done)
+			}()
+
+			select {
+			case p := <-panicked:
+				panic(p)
+			case <-done:
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+				dst := w.Header()
+				for name, values := range tw.header {
+					dst[name] = values
+				}
+				if tw.status == 0 {
+					tw.status = http.StatusOK
+				}
+				w.WriteHeader(tw.status)
+				_, _ = w.Write(tw.buf.Bytes())
+			case <-ctx.Done():
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+				tw.timedOut = true
+				if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+					options.Renderer(w, r, options.Status, ctx.Err())
+				}
+			}
+		})
+	}
+}
+
+// streamingRequest reports whether r expects a streamed or upgraded
+// response, which cannot be buffered.
+func streamingRequest(r *http.Request) bool {
+	return r.Header.Get("Upgrade") != "" ||
+		strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// timeoutWriter buffers the response of a handler running under Timeout.
+type timeoutWriter struct {
+	mu       sync.Mutex
+	header   http.Header
+	buf      bytes.Buffer
+	status   int
+	timedOut bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.status == 0 {
+		tw.status = http.StatusOK
+	}
+	return tw.buf.Write(b)
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.status != 0 || code < 200 {
+		return
+	}
+	tw.status = code
+}
@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/candango/httpok/session"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeout(t *testing.T) {
+	writeErr := make(chan error, 1)
+	handler := Timeout(20*time.Millisecond, &TimeoutOptions{
+		RouteTimeout: func(r *http.Request) time.Duration {
+			switch r.URL.Path {
+			case "/reports":
+				return time.Second
+			case "/unbounded":
+				return -1
+			}
+			return 0
+		},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-r.Context().Done()
+			time.Sleep(5 * time.Millisecond)
+			_, err := w.Write([]byte("late"))
+			writeErr <- err
+			return
+		}
+		_, hasDeadline := r.Context().Deadline()
+		if r.URL.Path == "/unbounded" {
+			assert.False(t, hasDeadline)
+		} else {
+			assert.True(t, hasDeadline)
+		}
+		if r.URL.Path == "/reports" {
+			time.Sleep(40 * time.Millisecond)
+		}
+		w.Header().Set("X-Handler", "done")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("ok"))
+	}))
+
+	for _, path := range []string{"/", "/reports", "/unbounded"} {
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, path,
+			nil))
+		assert.Equal(t, http.StatusCreated, response.Code, path)
+		assert.Equal(t, "done", response.Header().Get("X-Handler"), path)
+		assert.Equal(t, "ok", response.Body.String(), path)
+	}
+
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/slow",
+		nil))
+	assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+	assert.ErrorIs(t, <-writeErr, http.ErrHandlerTimeout)
+	assert.NotContains(t, response.Body.String(), "late")
+}
+
+func TestTimeoutRendersGatewayTimeout(t *testing.T) {
+	handler := Timeout(10*time.Millisecond, &TimeoutOptions{
+		Status:   http.StatusGatewayTimeout,
+		Renderer: JSONErrorRenderer,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusGatewayTimeout, response.Code)
+	assert.JSONEq(t, `{"status":504,"error":"Gateway Timeout"}`,
+		response.Body.String())
+}
+
+func TestTimeoutExemptsStreaming(t *testing.T) {
+	handler := Timeout(10*time.Millisecond, &TimeoutOptions{
+		Exempt: func(r *http.Request) bool { return r.URL.Path == "/hook" },
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, hasDeadline := r.Context().Deadline()
+		assert.False(t, hasDeadline)
+		_, ok := w.(http.Flusher)
+		assert.True(t, ok, "streaming handlers get the original writer")
+	}))
+
+	events := httptest.NewRequest(http.MethodGet, "/events", nil)
+	events.Header.Set("Accept", "text/event-stream")
+	upgrade := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	upgrade.Header.Set("Connection", "Upgrade")
+	upgrade.Header.Set("Upgrade", "websocket")
+	for _, request := range []*http.Request{events, upgrade,
+		httptest.NewRequest(http.MethodPost, "/hook", nil)} {
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+	}
+}
+
+func TestTimeoutPropagatesPanics(t *testing.T) {
+	handler := Chain(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		}),
+		Recovery(&RecoveryOptions{Logger: &recordingLogger{}}),
+		Timeout(time.Second, nil),
+	)
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusInternalServerError, response.Code)
+}
+
+func TestTimeoutStopsSessionStoreIO(t *testing.T) {
+	store := newCountingStore()
+	var setsBefore int
+	finished := make(chan struct{})
+	handler := Chain(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sess, err := session.SessionFromContext(r.Context())
+			assert.NoError(t, err)
+			setsBefore, _ = store.calls()
+			<-r.Context().Done()
+			assert.NoError(t, sess.Set("late", true))
+		}),
+		Timeout(20*time.Millisecond, nil),
+		func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter,
+				r *http.Request) {
+				defer close(finished)
+				next.ServeHTTP(w, r)
+			})
+		},
+		Sessioned(session.NewStoreEngine(store)),
+	)
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+
+	<-finished
+	setsAfter, _ := store.calls()
+	assert.Equal(t, setsBefore, setsAfter,
+		"the changed session is not saved after the deadline")
+}
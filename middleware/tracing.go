@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/candango/httpok"
+	"github.com/candango/httpok/tracing"
+)
+
+// Tracing creates a middleware that records a server span for every request.
+//
+// The span continues the trace received in the traceparent and tracestate
+// headers, or starts a new trace. It carries the request method, path, status
+// code and request ID, is marked as failed for 5xx responses, and is renamed
+// after the matched http.ServeMux pattern when one is available. Wrap the mux
+// with RecordRoute when middleware in between replaces the request. Handlers
+// reach the span through tracing.SpanFromContext, and outbound calls made
+// with tracing.Transport become its children.
+//
+// Spans of a trace whose traceparent has the sampled flag cleared are not
+// exported, but the trace is still propagated. A nil tracer records spans
+// without exporting them.
+func Tracing(tracer *tracing.Tracer) func(http.Handler) http.Handler {
+	if tracer == nil {
+		tracer = &tracing.Tracer{}
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			if sc, ok := tracing.Extract(r.Header); ok {
+				ctx = tracing.ContextWithRemoteSpanContext(ctx, sc)
+			}
+			ctx, span := tracer.Start(ctx, "HTTP "+r.Method,
+				tracing.SpanKindServer)
+			defer span.End()
+			span.SetAttribute("http.request.method", r.Method)
+			span.SetAttribute("url.path", r.URL.Path)
+			if id := httpok.RequestIDFromContext(ctx); id != "" {
+				span.SetAttribute("http.request.id", id)
+			}
+
+			wrapped := &httpok.WrappedWriter{
+				ResponseWriter: w,
+				StatusCode:     http.StatusOK,
+			}
+			req := withRoute(r.WithContext(ctx))
+			next.ServeHTTP(wrapped, req)
+
+			if pattern := RoutePattern(req); pattern != "" {
+				span.SetName(pattern)
+				span.SetAttribute("http.route", pattern)
+			}
+			span.SetAttribute("http.response.status_code", wrapped.StatusCode)
+			if wrapped.StatusCode >= 500 {
+				span.SetStatus(tracing.StatusError,
+					http.StatusText(wrapped.StatusCode))
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/candango/httpok/session"
+	"github.com/candango/httpok/tracing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracingRecordsServerSpan(t *testing.T) {
+	exporter := tracing.NewMemoryExporter()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		assert.NotNil(t, tracing.SpanFromContext(r.Context()))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	handler := Tracing(tracing.NewTracer(exporter))(mux)
+
+	request := httptest.NewRequest(http.MethodGet, "/items/1", nil)
+	request.Header.Set("traceparent",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	request.Header.Set("tracestate", "vendor=a")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	spans := exporter.Spans()
+	if assert.Len(t, spans, 1) {
+		span := spans[0]
+		assert.Equal(t, "GET /items/{id}", span.Name)
+		assert.Equal(t, tracing.SpanKindServer, span.Kind)
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.TraceID)
+		assert.Equal(t, "00f067aa0ba902b7", span.ParentSpanID)
+		assert.Equal(t, "vendor=a", span.TraceState)
+		assert.Equal(t, tracing.StatusError, span.Status)
+		assert.Equal(t, http.StatusServiceUnavailable,
+			span.Attributes["http.response.status_code"])
+		assert.Positive(t, span.Duration)
+	}
+}
+
+func TestTracingRecordsSessionSpans(t *testing.T) {
+	exporter := tracing.NewMemoryExporter()
+	engine := session.NewStoreEngine(session.NewMemoryStore())
+	handler := Chain(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sess, err := session.SessionFromContext(r.Context())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			assert.NoError(t, sess.Set("key", "value"))
+		}),
+		Tracing(tracing.NewTracer(exporter)),
+		Sessioned(engine),
+	)
+
+	handler.ServeHTTP(httptest.NewRecorder(),
+		httptest.NewRequest(http.MethodGet, "/", nil))
+
+	spans := map[string]tracing.SpanData{}
+	for _, span := range exporter.Spans() {
+		spans[span.Name] = span
+	}
+	server := spans["HTTP GET"]
+	assert.NotEmpty(t, server.SpanID)
+	for _, name := range []string{"session.GetSession", "session.SaveSession"} {
+		assert.Equal(t, server.SpanID, spans[name].ParentSpanID, name)
+	}
+	for _, name := range []string{"session.store.GetVersioned",
+		"session.store.CompareAndSet"} {
+		assert.Contains(t, spans, name)
+		assert.Equal(t, server.TraceID, spans[name].TraceID, name)
+	}
+	assert.Equal(t, spans["session.SaveSession"].SpanID,
+		spans["session.store.CompareAndSet"].ParentSpanID)
+}
+
+func TestTracingSkipsUnsampledTraces(t *testing.T) {
+	exporter := tracing.NewMemoryExporter()
+	handler := Tracing(tracing.NewTracer(exporter))(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			span := tracing.SpanFromContext(r.Context())
+			if assert.NotNil(t, span) {
+				assert.False(t, span.SpanContext().Sampled())
+			}
+		}))
+
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set("traceparent",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	assert.Empty(t, exporter.Spans())
+}
+
+func TestTracingWithNilTracer(t *testing.T) {
+	handler := Tracing(nil)(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			assert.NotNil(t, tracing.SpanFromContext(r.Context()))
+			w.WriteHeader(http.StatusNoContent)
+		}))
+
+	recorder := httptest.NewRecorder()
+	assert.NotPanics(t, func() {
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+}
+
+func TestTracingRouteBehindMiddleware(t *testing.T) {
+	exporter := tracing.NewMemoryExporter()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /items/{id}", func(http.ResponseWriter, *http.Request) {})
+	handler := Chain(RecordRoute(mux),
+		Tracing(tracing.NewTracer(exporter)),
+		RequestID(nil),
+		Sessioned(session.NewStoreEngine(session.NewMemoryStore())),
+	)
+
+	handler.ServeHTTP(httptest.NewRecorder(),
+		httptest.NewRequest(http.MethodGet, "/items/1", nil))
+
+	spans := exporter.Spans()
+	if assert.NotEmpty(t, spans) {
+		server := spans[len(spans)-1]
+		assert.Equal(t, "GET /items/{id}", server.Name)
+		assert.Equal(t, "GET /items/{id}", server.Attributes["http.route"])
+	}
+}
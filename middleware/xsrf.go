@@ -0,0 +1,193 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"html"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/candango/httpok/security"
+)
+
+// ContextXSRFValue is the request context key holding the XSRF state set by
+// the XSRF middleware.
+const ContextXSRFValue = "HTTPOKXSRFCTXVALUE"
+
+// XSRF errors passed to the ErrorRenderer. The messages follow Tornado's.
+var (
+	ErrXSRFMissing  = errors.New("'_xsrf' argument missing from POST")
+	ErrXSRFInvalid  = errors.New("'_xsrf' argument has invalid format")
+	ErrXSRFMismatch = errors.New("XSRF cookie does not match POST argument")
+)
+
+// XSRFOptions configures the XSRF middleware.
+type XSRFOptions struct {
+	// CookieName is the token cookie name. Empty uses "_xsrf", Tornado's
+	// default.
+	CookieName string
+	// FieldName is the form field carrying the token. Empty uses "_xsrf".
+	FieldName string
+	// Version is the token format issued to clients, 1 or 2. Zero uses 2.
+	// Both versions are always accepted.
+	Version int
+	// CookiePath is the cookie path. Empty uses "/".
+	CookiePath string
+	// CookieDomain is the cookie domain. Set it to the shared parent domain
+	// when Tornado services must accept the same cookie.
+	CookieDomain string
+	// CookieMaxAge is the cookie lifetime. Zero issues a browser session
+	// cookie.
+	CookieMaxAge time.Duration
+	// CookieSecure marks the cookie Secure.
+	CookieSecure bool
+	// CookieSameSite is the cookie SameSite mode. Zero uses Lax.
+	CookieSameSite http.SameSite
+	// Exempt reports whether r skips token validation, for example webhook
+	// endpoints authenticated by other means.
+	Exempt func(r *http.Request) bool
+	// Renderer writes the 403 response. A nil renderer uses
+	// TextErrorRenderer.
+	Renderer ErrorRenderer
+}
+
+// xsrfState is the per-request token state stored in the context.
+type xsrfState struct {
+	token     []byte
+	timestamp time.Time
+	version   int
+	field     string
+	encoded   string
+}
+
+// XSRF creates a middleware protecting against cross-site request forgery
+// with Tornado-compatible "_xsrf" tokens.
+//
+// The token is read from the cookie, accepting Tornado's version 1 and 2
+// formats, or generated and set as a cookie when missing. The cookie is not
+// HttpOnly so scripts can copy it into a header. Requests with methods other
+// than GET, HEAD and OPTIONS must carry the token in the X-XSRFToken or
+// X-CSRFToken header or in the form field, otherwise they are answered with
+// 403 Forbidden. Tokens are compared in constant time after unmasking, so
+// tokens issued by Tornado are accepted here and the other way around, as
+// long as both read the same cookie.
+//
+// Handlers and templates obtain the token with XSRFToken and XSRFFormHTML.
+func XSRF(opts *XSRFOptions) func(http.Handler) http.Handler {
+	var options XSRFOptions
+	if opts != nil {
+		options = *opts
+	}
+	if options.CookieName == "" {
+		options.CookieName = "_xsrf"
+	}
+	if options.FieldName == "" {
+		options.FieldName = "_xsrf"
+	}
+	if options.Version != 1 {
+		options.Version = 2
+	}
+	if options.CookiePath == "" {
+		options.CookiePath = "/"
+	}
+	if options.CookieSameSite == 0 {
+		options.CookieSameSite = http.SameSiteLaxMode
+	}
+	if options.Renderer == nil {
+		options.Renderer = TextErrorRenderer
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			state := &xsrfState{field: options.FieldName}
+			if cookie, err := r.Cookie(options.CookieName); err == nil {
+				token, timestamp, _, ok := security.DecodeXSRFToken(cookie.Value)
+				if ok && len(token) != 0 {
+					state.token = token
+					state.timestamp = timestamp
+				}
+			}
+			if state.token == nil {
+				state.token = security.NewXSRFToken()
+				state.timestamp = time.Now()
+				state.encoded = security.EncodeXSRFToken(state.token,
+					options.Version, state.timestamp)
+				http.SetCookie(w, xsrfCookie(options, state.encoded))
+			}
+			state.version = options.Version
+
+			if !xsrfSafeMethod(r.Method) &&
+				(options.Exempt == nil || !options.Exempt(r)) {
+				if err := checkXSRF(r, options, state.token); err != nil {
+					options.Renderer(w, r, http.StatusForbidden, err)
+					return
+				}
+			}
+			ctx := context.WithValue(r.Context(), ContextXSRFValue, state)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// XSRFToken returns the encoded XSRF token for the request, or an empty
+// string when the XSRF middleware is not in the chain. Every call within a
+// request returns the same value.
+func XSRFToken(ctx context.Context) string {
+	state, ok := ctx.Value(ContextXSRFValue).(*xsrfState)
+	if !ok {
+		return ""
+	}
+	if state.encoded == "" {
+		state.encoded = security.EncodeXSRFToken(state.token, state.version,
+			state.timestamp)
+	}
+	return state.encoded
+}
+
+// XSRFFormHTML returns a hidden input carrying the XSRF token, for use in
+// templates, or an empty value when the XSRF middleware is not in the chain.
+func XSRFFormHTML(ctx context.Context) template.HTML {
+	state, ok := ctx.Value(ContextXSRFValue).(*xsrfState)
+	if !ok {
+		return ""
+	}
+	return template.HTML(`<input type="hidden" name="` +
+		html.EscapeString(state.field) + `" value="` +
+		html.EscapeString(XSRFToken(ctx)) + `"/>`)
+}
+
+// checkXSRF validates the token submitted with r against the cookie token.
+func checkXSRF(r *http.Request, options XSRFOptions, expected []byte) error {
+	submitted := r.Header.Get("X-XSRFToken")
+	if submitted == "" {
+		submitted = r.Header.Get("X-CSRFToken")
+	}
+	if submitted == "" {
+		submitted = r.FormValue(options.FieldName)
+	}
+	if submitted == "" {
+		return ErrXSRFMissing
+	}
+	token, _, _, ok := security.DecodeXSRFToken(submitted)
+	if !ok || len(token) == 0 {
+		return ErrXSRFInvalid
+	}
+	if !security.XSRFTokensEqual(token, expected) {
+		return ErrXSRFMismatch
+	}
+	return nil
+}
+
+func xsrfCookie(options XSRFOptions, value string) *http.Cookie {
+	cookie := newCookie(options.CookieName, value, options.CookieMaxAge)
+	cookie.Path = options.CookiePath
+	cookie.Domain = options.CookieDomain
+	cookie.Secure = options.CookieSecure
+	cookie.SameSite = options.CookieSameSite
+	return cookie
+}
+
+func xsrfSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead ||
+		method == http.MethodOptions
+}
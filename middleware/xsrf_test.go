@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/candango/httpok/security"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestXSRF(t *testing.T) {
+	var seen string
+	handler := XSRF(&XSRFOptions{
+		Exempt: func(r *http.Request) bool {
+			return r.URL.Path == "/hooks"
+		},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = XSRFToken(r.Context())
+		assert.Equal(t, seen, XSRFToken(r.Context()))
+	}))
+
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, response.Code)
+	cookies := response.Result().Cookies()
+	assert.Len(t, cookies, 1)
+	cookie := cookies[0]
+	assert.Equal(t, "_xsrf", cookie.Name)
+	assert.False(t, cookie.HttpOnly)
+	assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+	assert.Equal(t, cookie.Value, seen)
+	cookieToken, _, version, _ := security.DecodeXSRFToken(cookie.Value)
+	assert.Equal(t, 2, version)
+
+	post := func(path string, header http.Header,
+		form url.Values) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, path,
+			strings.NewReader(form.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for name, values := range header {
+			request.Header[name] = values
+		}
+		request.AddCookie(cookie)
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		return response
+	}
+
+	response = post("/", nil, nil)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	response = post("/", http.Header{"X-Xsrftoken": {"2|zz|zz|1"}}, nil)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	other := security.EncodeXSRFToken(security.NewXSRFToken(), 2,
+		time.Now())
+	response = post("/", http.Header{"X-Csrftoken": {other}}, nil)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+
+	// A freshly masked token and the version 1 form of the cookie token both
+	// match the cookie.
+	remasked := security.EncodeXSRFToken(cookieToken, 2, time.Now())
+	assert.NotEqual(t, cookie.Value, remasked)
+	response = post("/", http.Header{"X-Xsrftoken": {remasked}}, nil)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Empty(t, response.Result().Cookies())
+
+	response = post("/", nil, url.Values{
+		"_xsrf": {security.EncodeXSRFToken(cookieToken, 1, time.Now())},
+	})
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	response = post("/hooks", nil, nil)
+	assert.Equal(t, http.StatusOK, response.Code)
+}
+
+func TestXSRFAcceptsTornadoCookie(t *testing.T) {
+	// Tornado writes version 1 cookies as the hexadecimal token when
+	// xsrf_cookie_version is 1.
+	handler := XSRF(nil)(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := httptest.NewRequest(http.MethodPost, "/", nil)
+	request.AddCookie(&http.Cookie{
+		Name:  "_xsrf",
+		Value: "000102030405060708090a0b0c0d0e0f",
+	})
+	request.Header.Set("X-XSRFToken",
+		"2|01020304|0103010705070503090b090f0d0f0d0b|1700000000")
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	assert.Equal(t, http.StatusOK, response.Code)
+}
+
+func TestXSRFFormHTML(t *testing.T) {
+	assert.Empty(t, XSRFToken(context.Background()))
+	assert.Empty(t, XSRFFormHTML(context.Background()))
+
+	var form string
+	handler := XSRF(&XSRFOptions{Version: 1})(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			form = string(XSRFFormHTML(r.Context()))
+		}))
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	value := response.Result().Cookies()[0].Value
+	assert.Len(t, value, 32)
+	assert.Equal(t, `<input type="hidden" name="_xsrf" value="`+value+`"/>`,
+		form)
+}
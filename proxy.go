@@ -0,0 +1,69 @@
+package httpok
+
+import (
+	"context"
+	"net"
+	"net/http"
+)
+
+// ContextProxyInfoValue is the context key for storing the ProxyInfo resolved
+// from trusted proxy headers.
+const ContextProxyInfoValue = "HTTPOKPROXYINFOCTXVALUE"
+
+// ProxyInfo describes the original client request as reported by trusted
+// reverse proxies.
+type ProxyInfo struct {
+	// ClientIP is the address of the client that reached the first trusted
+	// proxy.
+	ClientIP string
+	// Scheme is "http" or "https".
+	Scheme string
+	// Host is the host, with an optional port, requested by the client.
+	Host string
+}
+
+// ContextWithProxyInfo returns a copy of ctx carrying info.
+func ContextWithProxyInfo(ctx context.Context, info ProxyInfo) context.Context {
+	return context.WithValue(ctx, ContextProxyInfoValue, info)
+}
+
+// ProxyInfoFromContext retrieves the ProxyInfo stored in the context.
+func ProxyInfoFromContext(ctx context.Context) (ProxyInfo, bool) {
+	info, ok := ctx.Value(ContextProxyInfoValue).(ProxyInfo)
+	return info, ok
+}
+
+// ClientIP returns the client IP address of r, resolved from trusted proxy
+// headers when available and taken from RemoteAddr otherwise.
+func ClientIP(r *http.Request) string {
+	if info, ok := ProxyInfoFromContext(r.Context()); ok && info.ClientIP != "" {
+		return info.ClientIP
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// RequestScheme returns "https" or "http" for r, resolved from trusted proxy
+// headers when available and from the connection otherwise.
+func RequestScheme(r *http.Request) string {
+	if info, ok := ProxyInfoFromContext(r.Context()); ok && info.Scheme != "" {
+		return info.Scheme
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// RequestHost returns the host requested by the client, resolved from
+// trusted proxy headers when available and taken from Request.Host
+// otherwise.
+func RequestHost(r *http.Request) string {
+	if info, ok := ProxyInfoFromContext(r.Context()); ok && info.Host != "" {
+		return info.Host
+	}
+	return r.Host
+}
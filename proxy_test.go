@@ -0,0 +1,29 @@
+package httpok
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestInfoHelpers(t *testing.T) {
+	request := httptest.NewRequest(http.MethodGet, "http://app.test/", nil)
+	request.RemoteAddr = "192.0.2.10:4321"
+	assert.Equal(t, "192.0.2.10", ClientIP(request))
+	assert.Equal(t, "http", RequestScheme(request))
+	assert.Equal(t, "app.test", RequestHost(request))
+
+	request.TLS = &tls.ConnectionState{}
+	assert.Equal(t, "https", RequestScheme(request))
+
+	_, ok := ProxyInfoFromContext(request.Context())
+	assert.False(t, ok)
+	request = request.WithContext(ContextWithProxyInfo(request.Context(),
+		ProxyInfo{ClientIP: "203.0.113.5", Scheme: "http", Host: "www.test"}))
+	assert.Equal(t, "203.0.113.5", ClientIP(request))
+	assert.Equal(t, "http", RequestScheme(request))
+	assert.Equal(t, "www.test", RequestHost(request))
+}
@@ -0,0 +1,159 @@
+// Package ratelimit provides request rate limiting algorithms on top of
+// pluggable counter storage.
+package ratelimit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/candango/httpok/session"
+)
+
+// Counter stores the rate limiting state of each key.
+type Counter interface {
+	// Update atomically replaces the state stored for key with the state
+	// returned by fn, which receives the current state or nil when there is
+	// none or it expired. The new state expires after ttl. When fn returns
+	// an error the stored state is left unchanged and the error is returned.
+	Update(ctx context.Context, key string, ttl time.Duration,
+		fn func(state []byte) ([]byte, error)) error
+}
+
+// memoryShards is the number of independently locked maps in a
+// MemoryCounter.
+const memoryShards = 64
+
+// memorySweepInterval is how often a shard drops its expired entries.
+const memorySweepInterval = time.Minute
+
+// MemoryCounter is an in-process Counter sharded by key to reduce lock
+// contention.
+type MemoryCounter struct {
+	shards [memoryShards]memoryShard
+	now    func() time.Time
+}
+
+type memoryShard struct {
+	mu        sync.Mutex
+	entries   map[string]memoryCounterEntry
+	nextSweep time.Time
+}
+
+type memoryCounterEntry struct {
+	state   []byte
+	expires time.Time
+}
+
+// NewMemoryCounter creates an empty MemoryCounter.
+func NewMemoryCounter() *MemoryCounter {
+	c := &MemoryCounter{now: time.Now}
+	for i := range c.shards {
+		c.shards[i].entries = map[string]memoryCounterEntry{}
+	}
+	return c
+}
+
+// Update implements Counter. Expired entries are removed lazily, sweeping a
+// shard at most once per minute.
+func (c *MemoryCounter) Update(_ context.Context, key string,
+	ttl time.Duration, fn func(state []byte) ([]byte, error)) error {
+	shard := &c.shards[stripe(key, memoryShards)]
+	now := c.now()
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if now.After(shard.nextSweep) {
+		for k, entry := range shard.entries {
+			if !now.Before(entry.expires) {
+				delete(shard.entries, k)
+			}
+		}
+		shard.nextSweep = now.Add(memorySweepInterval)
+	}
+
+	var current []byte
+	if entry, ok := shard.entries[key]; ok && now.Before(entry.expires) {
+		current = entry.state
+	}
+	state, err := fn(current)
+	if err != nil {
+		return err
+	}
+	shard.entries[key] = memoryCounterEntry{
+		state:   state,
+		expires: now.Add(ttl),
+	}
+	return nil
+}
+
+// StoreCounterPrefix prefixes the store IDs used by a StoreCounter, keeping
+// them apart from session IDs sharing the store.
+const StoreCounterPrefix = "ratelimit_"
+
+// storeLocks is the number of lock stripes in a StoreCounter.
+const storeLocks = 64
+
+// StoreCounter is a Counter persisting its state in a session.Store, so
+// limits can be kept wherever sessions already are.
+//
+// Keys are hashed with SHA-256 into IDs valid for every store, including
+// FileStore. The stored value carries its own expiration because the Store
+// contract has no per-entry TTL; expired entries read as empty and are
+// removed by the store's regular Purge. Updates are serialized by local
+// locks only, so processes sharing a store may occasionally lose increments
+// under contention.
+type StoreCounter struct {
+	Store session.Store
+	locks [storeLocks]sync.Mutex
+	now   func() time.Time
+}
+
+// NewStoreCounter creates a StoreCounter on top of store.
+func NewStoreCounter(store session.Store) *StoreCounter {
+	return &StoreCounter{Store: store, now: time.Now}
+}
+
+// Update implements Counter.
+func (c *StoreCounter) Update(ctx context.Context, key string,
+	ttl time.Duration, fn func(state []byte) ([]byte, error)) error {
+	sum := sha256.Sum256([]byte(key))
+	id := StoreCounterPrefix + hex.EncodeToString(sum[:])
+	lock := &c.locks[stripe(key, storeLocks)]
+	lock.Lock()
+	defer lock.Unlock()
+
+	now := c.now()
+	var current []byte
+	exists, err := c.Store.Exists(ctx, id)
+	if err != nil {
+		return err
+	}
+	if exists {
+		value, err := c.Store.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+		if len(value) >= 8 &&
+			now.UnixNano() < int64(binary.BigEndian.Uint64(value)) {
+			current = value[8:]
+		}
+	}
+	state, err := fn(current)
+	if err != nil {
+		return err
+	}
+	value := binary.BigEndian.AppendUint64(make([]byte, 0, 8+len(state)),
+		uint64(now.Add(ttl).UnixNano()))
+	return c.Store.Set(ctx, id, append(value, state...))
+}
+
+// stripe maps key to one of n lock stripes.
+func stripe(key string, n int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
@@ -0,0 +1,175 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"time"
+)
+
+// errInvalidState is returned when a counter holds state written by a
+// different algorithm or corrupted in storage.
+var errInvalidState = errors.New("ratelimit: invalid counter state")
+
+// Result is the outcome of a rate limiting decision.
+type Result struct {
+	// Allowed reports whether the request may proceed.
+	Allowed bool
+	// Limit is the number of requests allowed per period.
+	Limit int
+	// Remaining is the number of requests still allowed now.
+	Remaining int
+	// Reset is the time until the quota resets: until the bucket is full for
+	// TokenBucket and until the current window ends for SlidingWindow.
+	Reset time.Duration
+	// RetryAfter is the time until the next request is allowed. It is zero
+	// when Allowed is true.
+	RetryAfter time.Duration
+}
+
+// Limiter decides whether a request identified by key may proceed.
+type Limiter interface {
+	Allow(ctx context.Context, key string) (Result, error)
+}
+
+// TokenBucket is a Limiter allowing bursts of up to Limit requests, with
+// tokens refilled continuously at Limit per Period. Limit must be positive.
+type TokenBucket struct {
+	Counter Counter
+	Limit   int
+	Period  time.Duration
+	now     func() time.Time
+}
+
+// NewTokenBucket creates a TokenBucket storing its state in counter.
+func NewTokenBucket(counter Counter, limit int,
+	period time.Duration) *TokenBucket {
+	return &TokenBucket{
+		Counter: counter,
+		Limit:   limit,
+		Period:  period,
+		now:     time.Now,
+	}
+}
+
+// Allow implements Limiter. The state is the token count and the time it
+// was last refilled.
+func (b *TokenBucket) Allow(ctx context.Context, key string) (Result,
+	error) {
+	result := Result{Limit: b.Limit}
+	perToken := b.Period / time.Duration(b.Limit)
+	err := b.Counter.Update(ctx, key, b.Period,
+		func(state []byte) ([]byte, error) {
+			now := b.now().UnixNano()
+			tokens := float64(b.Limit)
+			if state != nil {
+				if len(state) != 16 {
+					return nil, errInvalidState
+				}
+				tokens = math.Float64frombits(binary.BigEndian.Uint64(state))
+				last := int64(binary.BigEndian.Uint64(state[8:]))
+				if elapsed := now - last; elapsed > 0 {
+					tokens += float64(elapsed) / float64(perToken)
+				}
+				tokens = min(tokens, float64(b.Limit))
+			}
+			if tokens >= 1 {
+				tokens--
+				result.Allowed = true
+			} else {
+				result.RetryAfter = time.Duration((1 - tokens) *
+					float64(perToken))
+			}
+			result.Remaining = int(tokens)
+			result.Reset = time.Duration((float64(b.Limit) - tokens) *
+				float64(perToken))
+			state = binary.BigEndian.AppendUint64(make([]byte, 0, 16),
+				math.Float64bits(tokens))
+			return binary.BigEndian.AppendUint64(state, uint64(now)), nil
+		})
+	return result, err
+}
+
+// SlidingWindow is a Limiter allowing Limit requests per Window. It
+// approximates a sliding log by weighting the previous fixed window's count
+// by how much of it still overlaps the sliding window, which keeps the
+// state at a constant size per key. Limit must be positive.
+type SlidingWindow struct {
+	Counter Counter
+	Limit   int
+	Window  time.Duration
+	now     func() time.Time
+}
+
+// NewSlidingWindow creates a SlidingWindow storing its state in counter.
+func NewSlidingWindow(counter Counter, limit int,
+	window time.Duration) *SlidingWindow {
+	return &SlidingWindow{
+		Counter: counter,
+		Limit:   limit,
+		Window:  window,
+		now:     time.Now,
+	}
+}
+
+// Allow implements Limiter. The state is the current fixed window start and
+// the counts of the current and previous windows.
+func (s *SlidingWindow) Allow(ctx context.Context, key string) (Result,
+	error) {
+	result := Result{Limit: s.Limit}
+	window := int64(s.Window)
+	err := s.Counter.Update(ctx, key, 2*s.Window,
+		func(state []byte) ([]byte, error) {
+			now := s.now().UnixNano()
+			start := now - now%window
+			var current, previous int64
+			if state != nil {
+				if len(state) != 24 {
+					return nil, errInvalidState
+				}
+				stored := int64(binary.BigEndian.Uint64(state))
+				switch stored {
+				case start:
+					current = int64(binary.BigEndian.Uint64(state[8:]))
+					previous = int64(binary.BigEndian.Uint64(state[16:]))
+				case start - window:
+					previous = int64(binary.BigEndian.Uint64(state[8:]))
+				}
+			}
+			elapsed := now - start
+			weight := float64(window-elapsed) / float64(window)
+			estimate := float64(previous)*weight + float64(current)
+			limit := float64(s.Limit)
+			if estimate+1 <= limit {
+				current++
+				estimate++
+				result.Allowed = true
+			} else {
+				result.RetryAfter = s.retryAfter(elapsed, current, previous)
+			}
+			result.Remaining = max(0, int(limit-math.Ceil(estimate)))
+			result.Reset = time.Duration(window - elapsed)
+			state = binary.BigEndian.AppendUint64(make([]byte, 0, 24),
+				uint64(start))
+			state = binary.BigEndian.AppendUint64(state, uint64(current))
+			return binary.BigEndian.AppendUint64(state, uint64(previous)), nil
+		})
+	return result, err
+}
+
+// retryAfter returns how long until the weighted count drops enough to
+// allow one more request.
+func (s *SlidingWindow) retryAfter(elapsed, current,
+	previous int64) time.Duration {
+	window := float64(s.Window)
+	allowed := float64(s.Limit - 1)
+	if current <= int64(s.Limit-1) && previous > 0 {
+		// The previous window decays within the current one.
+		at := window * (1 - (allowed-float64(current))/float64(previous))
+		return time.Duration(at - float64(elapsed))
+	}
+	// The current window becomes the previous one and has to decay.
+	at := window * (1 - allowed/float64(current))
+	return time.Duration(window - float64(elapsed) + at)
+}
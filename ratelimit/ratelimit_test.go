@@ -0,0 +1,193 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/candango/httpok/session"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1700000000, 0)}
+}
+
+func newMemoryCounter(clock *fakeClock) *MemoryCounter {
+	counter := NewMemoryCounter()
+	counter.now = clock.Now
+	return counter
+}
+
+func TestTokenBucket(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	bucket := NewTokenBucket(newMemoryCounter(clock), 3, 3*time.Second)
+	bucket.now = clock.Now
+
+	for i := 2; i >= 0; i-- {
+		result, err := bucket.Allow(ctx, "key")
+		assert.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, i, result.Remaining)
+	}
+	result, err := bucket.Allow(ctx, "key")
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 3, result.Limit)
+	assert.Equal(t, time.Second, result.RetryAfter)
+	assert.Equal(t, 3*time.Second, result.Reset)
+
+	other, err := bucket.Allow(ctx, "other")
+	assert.NoError(t, err)
+	assert.True(t, other.Allowed, "keys are limited independently")
+
+	clock.Advance(1500 * time.Millisecond)
+	result, err = bucket.Allow(ctx, "key")
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+
+	clock.Advance(time.Hour)
+	result, err = bucket.Allow(ctx, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Remaining, "bucket refills up to the limit")
+}
+
+func TestSlidingWindow(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	limiter := NewSlidingWindow(newMemoryCounter(clock), 4, 10*time.Second)
+	limiter.now = clock.Now
+
+	for i := 3; i >= 0; i-- {
+		result, err := limiter.Allow(ctx, "key")
+		assert.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, i, result.Remaining)
+	}
+	result, err := limiter.Allow(ctx, "key")
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 10*time.Second, result.Reset)
+	// Four requests in the current window decay below the limit a quarter
+	// of the way into the next window.
+	assert.Equal(t, 12500*time.Millisecond, result.RetryAfter)
+
+	clock.Advance(12 * time.Second)
+	result, err = limiter.Allow(ctx, "key")
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 500*time.Millisecond, result.RetryAfter)
+
+	clock.Advance(500 * time.Millisecond)
+	result, err = limiter.Allow(ctx, "key")
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+
+	clock.Advance(20 * time.Second)
+	result, err = limiter.Allow(ctx, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, result.Remaining, "old windows are forgotten")
+}
+
+func TestMemoryCounterExpires(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	counter := newMemoryCounter(clock)
+	update := func() []byte {
+		var seen []byte
+		assert.NoError(t, counter.Update(ctx, "key", time.Second,
+			func(state []byte) ([]byte, error) {
+				seen = state
+				return []byte("state"), nil
+			}))
+		return seen
+	}
+
+	assert.Nil(t, update())
+	assert.Equal(t, []byte("state"), update())
+	clock.Advance(2 * time.Minute)
+	assert.Nil(t, update())
+	shard := &counter.shards[stripe("key", memoryShards)]
+	assert.Len(t, shard.entries, 1)
+}
+
+func TestStoreCounter(t *testing.T) {
+	stores := map[string]func(t *testing.T) session.Store{
+		"memory": func(t *testing.T) session.Store {
+			return session.NewMemoryStore()
+		},
+		"file": func(t *testing.T) session.Store {
+			store := &session.FileStore{Dir: t.TempDir()}
+			assert.NoError(t, store.Start(context.Background()))
+			return store
+		},
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			clock := newFakeClock()
+			store := newStore(t)
+			counter := NewStoreCounter(store)
+			counter.now = clock.Now
+			limiter := NewSlidingWindow(counter, 2, time.Minute)
+			limiter.now = clock.Now
+
+			// Keys may contain characters FileStore IDs cannot.
+			key := "ip:[2001:db8::1]/path"
+			for _, allowed := range []bool{true, true, false} {
+				result, err := limiter.Allow(ctx, key)
+				assert.NoError(t, err)
+				assert.Equal(t, allowed, result.Allowed)
+			}
+
+			clock.Advance(3 * time.Minute)
+			result, err := limiter.Allow(ctx, key)
+			assert.NoError(t, err)
+			assert.True(t, result.Allowed)
+			assert.Equal(t, 1, result.Remaining)
+
+			var wg sync.WaitGroup
+			for range 20 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, err := limiter.Allow(ctx, "concurrent")
+					assert.NoError(t, err)
+				}()
+			}
+			wg.Wait()
+			result, err = limiter.Allow(ctx, "concurrent")
+			assert.NoError(t, err)
+			assert.False(t, result.Allowed)
+		})
+	}
+}
+
+func TestInvalidState(t *testing.T) {
+	ctx := context.Background()
+	counter := NewMemoryCounter()
+	_, err := NewTokenBucket(counter, 1, time.Minute).Allow(ctx, "key")
+	assert.NoError(t, err)
+	_, err = NewSlidingWindow(counter, 1, time.Minute).Allow(ctx, "key")
+	assert.ErrorIs(t, err, errInvalidState)
+}
@@ -0,0 +1,81 @@
+package httpok
+
+import (
+	"context"
+	"net/http"
+)
+
+const (
+	// ContextRequestIDValue is the context key for storing the request ID.
+	ContextRequestIDValue = "HTTPOKREQUESTIDCTXVALUE"
+	// RequestIDHeader is the default header used to carry request IDs.
+	RequestIDHeader = "X-Request-ID"
+	// maxRequestIDLength bounds request IDs accepted from clients.
+	maxRequestIDLength = 128
+)
+
+// ContextWithRequestID returns a copy of ctx carrying the request ID.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ContextRequestIDValue, id)
+}
+
+// RequestIDFromContext retrieves the request ID from the context. It returns
+// an empty string when no request ID was stored.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(ContextRequestIDValue).(string)
+	return id
+}
+
+// ValidRequestID reports whether id is an acceptable request ID: between 1
+// and 128 characters taken from ASCII letters, digits, '-', '_', '.' and ':'.
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, char := range id {
+		if (char < 'a' || char > 'z') &&
+			(char < 'A' || char > 'Z') &&
+			(char < '0' || char > '9') &&
+			char != '-' && char != '_' && char != '.' && char != ':' {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestIDTransport is an http.RoundTripper that propagates the request ID
+// found in the outgoing request context to the upstream service.
+//
+// Build outgoing requests with the incoming request context, for example
+// http.NewRequestWithContext(r.Context(), ...), so the ID stored by the
+// RequestID middleware reaches the transport.
+type RequestIDTransport struct {
+	// Base is the transport used to send the request. A nil Base uses
+	// http.DefaultTransport.
+	Base http.RoundTripper
+	// Header is the header carrying the request ID. Empty uses
+	// RequestIDHeader.
+	Header string
+}
+
+// RoundTrip sets the request ID header, when the context carries an ID and
+// the request does not already define one, and sends the request through the
+// base transport.
+func (t *RequestIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	header := t.Header
+	if header == "" {
+		header = RequestIDHeader
+	}
+	id := RequestIDFromContext(req.Context())
+	if id == "" || req.Header.Get(header) != "" {
+		return base.RoundTrip(req)
+	}
+	// A RoundTripper must not modify the caller's request.
+	req = req.Clone(req.Context())
+	req.Header.Set(header, id)
+	return base.RoundTrip(req)
+}
@@ -0,0 +1,45 @@
+package httpok
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidRequestID(t *testing.T) {
+	assert.True(t, ValidRequestID("01HF8Z0W5T8Q5J3T4W5S6X7Y8Z"))
+	assert.True(t, ValidRequestID("svc:req-1.2_3"))
+	assert.False(t, ValidRequestID(""))
+	assert.False(t, ValidRequestID("with space"))
+	assert.False(t, ValidRequestID("line\nbreak"))
+	assert.False(t, ValidRequestID(string(make([]byte, 129))))
+}
+
+func TestRequestIDTransport(t *testing.T) {
+	received := make(chan string, 2)
+	upstream := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			received <- r.Header.Get(RequestIDHeader)
+		}))
+	defer upstream.Close()
+
+	client := &http.Client{Transport: &RequestIDTransport{}}
+	ctx := ContextWithRequestID(context.Background(), "req-42")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, upstream.URL, nil)
+	assert.NoError(t, err)
+	res, err := client.Do(req)
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, "req-42", <-received)
+	assert.Empty(t, req.Header.Get(RequestIDHeader))
+
+	req, err = http.NewRequest(http.MethodGet, upstream.URL, nil)
+	assert.NoError(t, err)
+	res, err = client.Do(req)
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Empty(t, <-received)
+}
@@ -1,6 +1,8 @@
 package security
 
 import (
+	cryptorand "crypto/rand"
+	"encoding/hex"
 	"math/rand"
 	"time"
 )
@@ -19,3 +21,47 @@ func RandomString(s int) string {
 	}
 	return string(r)
 }
+
+// RandomHex returns n bytes read from crypto/rand encoded as lowercase
+// hexadecimal, resulting in a string of 2*n characters.
+func RandomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = cryptorand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// crockfordAlphabet is the Crockford base32 alphabet used by ULIDs.
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewULID returns a Universally Unique Lexicographically Sortable
+// Identifier: a 48-bit millisecond timestamp followed by 80 bits from
+// crypto/rand, encoded as 26 Crockford base32 characters.
+func NewULID() string {
+	return newULID(time.Now())
+}
+
+func newULID(now time.Time) string {
+	var id [16]byte
+	ms := uint64(now.UnixMilli())
+	for i := 5; i >= 0; i-- {
+		id[i] = byte(ms)
+		ms >>= 8
+	}
+	_, _ = cryptorand.Read(id[6:])
+
+	// 128 bits are encoded from the most significant end in 5-bit groups,
+	// with the first character carrying only the top 3 bits.
+	out := make([]byte, 26)
+	hi := uint64(id[0])<<56 | uint64(id[1])<<48 | uint64(id[2])<<40 |
+		uint64(id[3])<<32 | uint64(id[4])<<24 | uint64(id[5])<<16 |
+		uint64(id[6])<<8 | uint64(id[7])
+	lo := uint64(id[8])<<56 | uint64(id[9])<<48 | uint64(id[10])<<40 |
+		uint64(id[11])<<32 | uint64(id[12])<<24 | uint64(id[13])<<16 |
+		uint64(id[14])<<8 | uint64(id[15])
+	for i := 25; i >= 0; i-- {
+		out[i] = crockfordAlphabet[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out)
+}
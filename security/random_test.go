@@ -0,0 +1,37 @@
+package security
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRandomHex(t *testing.T) {
+	value := RandomHex(16)
+	if len(value) != 32 {
+		t.Fatalf("RandomHex length = %d, want 32", len(value))
+	}
+	if strings.Trim(value, "0123456789abcdef") != "" {
+		t.Fatalf("RandomHex returned non hex value %q", value)
+	}
+	if value == RandomHex(16) {
+		t.Fatal("RandomHex returned the same value twice")
+	}
+}
+
+func TestNewULID(t *testing.T) {
+	earlier := newULID(time.UnixMilli(1700000000000))
+	later := newULID(time.UnixMilli(1700000000001))
+	if len(earlier) != 26 || len(later) != 26 {
+		t.Fatalf("ULID lengths = %d and %d, want 26", len(earlier), len(later))
+	}
+	if earlier[:10] != "01HF7YAT00" {
+		t.Fatalf("ULID timestamp = %q, want %q", earlier[:10], "01HF7YAT00")
+	}
+	if earlier >= later {
+		t.Fatalf("ULID %q does not sort before %q", earlier, later)
+	}
+	if strings.Trim(NewULID(), crockfordAlphabet) != "" {
+		t.Fatal("NewULID returned characters outside the Crockford alphabet")
+	}
+}
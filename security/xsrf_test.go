@@ -0,0 +1,95 @@
+package security
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeXSRFTokenAcceptsTornadoFormats(t *testing.T) {
+	token, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+
+	tests := []struct {
+		name    string
+		value   string
+		version int
+		want    []byte
+	}{
+		{
+			name:    "version 2",
+			value:   "2|01020304|0103010705070503090b090f0d0f0d0b|1700000000",
+			version: 2,
+			want:    token,
+		},
+		{
+			name:    "version 1 hex",
+			value:   "000102030405060708090a0b0c0d0e0f",
+			version: 1,
+			want:    token,
+		},
+		{
+			name:    "version 1 raw",
+			value:   "not-hex-token",
+			version: 1,
+			want:    []byte("not-hex-token"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, version, ok := DecodeXSRFToken(tt.value)
+			if !ok {
+				t.Fatalf("DecodeXSRFToken(%q) failed", tt.value)
+			}
+			if version != tt.version || !bytes.Equal(got, tt.want) {
+				t.Fatalf("DecodeXSRFToken(%q) = %x, version %d; want %x, "+
+					"version %d", tt.value, got, version, tt.want, tt.version)
+			}
+		})
+	}
+}
+
+func TestDecodeXSRFTokenRejectsMalformedValues(t *testing.T) {
+	for _, value := range []string{
+		"",
+		"3|01020304|0103|1700000000",
+		"2|01020304|0103010705070503090b090f0d0f0d0b",
+		"2|010203|0103010705070503090b090f0d0f0d0b|1700000000",
+		"2|01020304|zz|1700000000",
+		"2|01020304|0103010705070503090b090f0d0f0d0b|now",
+	} {
+		if _, _, _, ok := DecodeXSRFToken(value); ok {
+			t.Fatalf("DecodeXSRFToken(%q) succeeded", value)
+		}
+	}
+}
+
+func TestEncodeXSRFTokenRoundTrip(t *testing.T) {
+	token := NewXSRFToken()
+	if len(token) != XSRFTokenSize {
+		t.Fatalf("NewXSRFToken length = %d, want %d", len(token), XSRFTokenSize)
+	}
+	now := time.Unix(1700000000, 0)
+
+	first := EncodeXSRFToken(token, 2, now)
+	second := EncodeXSRFToken(token, 2, now)
+	if !strings.HasPrefix(first, "2|") || first == second {
+		t.Fatalf("version 2 tokens %q and %q are not freshly masked", first,
+			second)
+	}
+	for _, value := range []string{first, second,
+		EncodeXSRFToken(token, 1, now)} {
+		decoded, _, _, ok := DecodeXSRFToken(value)
+		if !ok || !XSRFTokensEqual(decoded, token) {
+			t.Fatalf("DecodeXSRFToken(%q) = %x, want %x", value, decoded, token)
+		}
+	}
+	_, timestamp, _, _ := DecodeXSRFToken(first)
+	if !timestamp.Equal(now) {
+		t.Fatalf("timestamp = %v, want %v", timestamp, now)
+	}
+	if XSRFTokensEqual(nil, nil) {
+		t.Fatal("empty tokens compared equal")
+	}
+}
@@ -75,8 +75,13 @@ type GracefulServer struct {
 	// ShutdownFunc runs during graceful shutdown before http.Server.Shutdown.
 	// It takes precedence over CancelFunc when both fields are configured.
 	ShutdownFunc GracefulShutdownFunc
-	cancelMutex  sync.Mutex
-	sigChan      chan os.Signal
+	// CertFile and KeyFile are the PEM files of the TLS certificate. When
+	// either is set, or when the http.Server TLSConfig provides
+	// certificates, Run serves HTTPS with ListenAndServeTLS.
+	CertFile    string
+	KeyFile     string
+	cancelMutex sync.Mutex
+	sigChan     chan os.Signal
 }
 
 // NewGracefulServer creates a new GracefulServer wrapping the given http.Server.
@@ -133,6 +138,25 @@ func (s *GracefulServer) WithShutdownFunc(shutdownFunc GracefulShutdownFunc) *Gr
 	return s
 }
 
+// WithTLS sets the certificate and key files used to serve HTTPS. Client
+// certificate authentication is configured through the http.Server
+// TLSConfig, for example with ClientAuth and ClientCAs.
+func (s *GracefulServer) WithTLS(certFile, keyFile string) *GracefulServer {
+	s.CertFile = certFile
+	s.KeyFile = keyFile
+	return s
+}
+
+// usesTLS reports whether Run serves HTTPS.
+func (s *GracefulServer) usesTLS() bool {
+	if s.CertFile != "" || s.KeyFile != "" {
+		return true
+	}
+	config := s.Server.TLSConfig
+	return config != nil && (len(config.Certificates) > 0 ||
+		config.GetCertificate != nil || config.GetConfigForClient != nil)
+}
+
 // TriggerShutdown programmatically requests a graceful shutdown of the server.
 // Returns an error if the shutdown cancel function is not available (e.g., Run
 // has not been called).
@@ -146,8 +170,8 @@ func (s *GracefulServer) TriggerShutdown() error {
 	return nil
 }
 
-// Run starts the HTTP server in a goroutine and listens for termination
-// signals to gracefully shut down.
+// Run starts the HTTP server, or the HTTPS server when TLS is configured, in
+// a goroutine and listens for termination signals to gracefully shut down.
 // It cancels the server runtime context when shutdown is triggered, then runs
 // the custom shutdown hook and HTTP shutdown using a separate shutdown context.
 // If ShutdownTimeout is set, that timeout applies to the shutdown context.
@@ -178,14 +202,27 @@ func (s *GracefulServer) Run(sig ...os.Signal) {
 		}
 	}
 
+	useTLS := s.usesTLS()
 	go func() {
+		if useTLS {
+			err := s.ListenAndServeTLS(s.CertFile, s.KeyFile)
+			if err != http.ErrServerClosed {
+				l.Fatalf("server %s HTTPS ListenAndServeTLS error: %v", s.Name,
+					err)
+			}
+			return
+		}
 		err := s.ListenAndServe()
 		if err != http.ErrServerClosed {
 			l.Fatalf("server %s HTTP ListenAndServe error: %v", s.Name, err)
 		}
 	}()
 
-	l.Printf("server %s started at %s", s.Name, s.Addr)
+	if useTLS {
+		l.Printf("server %s started with TLS at %s", s.Name, s.Addr)
+	} else {
+		l.Printf("server %s started at %s", s.Name, s.Addr)
+	}
 
 	go func() {
 		select {
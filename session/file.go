@@ -2,6 +2,8 @@ package session
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"os"
@@ -147,6 +149,11 @@ func (s *FileStore) Set(_ context.Context, id string, val []byte) error {
 	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
 		return err
 	}
+	return writeSessionFile(sessFile, val)
+}
+
+// writeSessionFile writes val to sessFile. The caller holds the store lock.
+func writeSessionFile(sessFile string, val []byte) error {
 	file, err := os.OpenFile(sessFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return err
@@ -248,6 +255,97 @@ func (s *FileStore) Touch(_ context.Context, id string) error {
 	return os.Chtimes(sessFile, now, now)
 }
 
+// GetAndTouch reads the session file for id and updates its modification
+// time while holding the store lock, so a concurrent Purge cannot remove it
+// in between.
+func (s *FileStore) GetAndTouch(_ context.Context, id string) ([]byte, bool,
+	error) {
+	sessFile, err := s.sessionPath(id)
+	if err != nil {
+		return nil, false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info, err := os.Lstat(sessFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, false, nil
+	}
+	data, err := os.ReadFile(sessFile)
+	if err != nil {
+		return nil, false, err
+	}
+	now := time.Now()
+	if err := os.Chtimes(sessFile, now, now); err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+// GetVersioned reads the session file for id like GetAndTouch and returns
+// the version of its content.
+func (s *FileStore) GetVersioned(ctx context.Context, id string) ([]byte,
+	uint64, bool, error) {
+	val, found, err := s.GetAndTouch(ctx, id)
+	if err != nil || !found {
+		return nil, 0, found, err
+	}
+	return val, fileVersion(val), true, nil
+}
+
+// CompareAndSet writes val to the session file for id only when the version
+// of its current content equals version, with version 0 requiring the file
+// to be absent. The check and the write hold the store lock, so they are
+// atomic within one FileStore but not across processes sharing Dir.
+func (s *FileStore) CompareAndSet(_ context.Context, id string, val []byte,
+	version uint64) (uint64, error) {
+	sessFile, err := s.sessionPath(id)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var current uint64
+	info, err := os.Lstat(sessFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+	if err == nil {
+		if !info.Mode().IsRegular() {
+			return 0, errors.New("session path is not a regular file")
+		}
+		data, err := os.ReadFile(sessFile)
+		if err != nil {
+			return 0, err
+		}
+		current = fileVersion(data)
+	}
+	if current != version {
+		return 0, ErrVersionConflict
+	}
+	if err := writeSessionFile(sessFile, val); err != nil {
+		return 0, err
+	}
+	return fileVersion(val), nil
+}
+
+// fileVersion derives a version from session file content, so FileStore
+// needs no metadata besides the file. Versions are never 0.
+func fileVersion(data []byte) uint64 {
+	sum := sha256.Sum256(data)
+	if version := binary.BigEndian.Uint64(sum[:8]); version != 0 {
+		return version
+	}
+	return 1
+}
+
 func (s *FileStore) sessionPath(id string) (string, error) {
 	if !validSessionID(id) {
 		return "", errInvalidSessionID
@@ -147,6 +147,60 @@ func TestFileSessionStore(t *testing.T) {
 		assert.Error(t, err)
 	})
 
+	t.Run("should get and touch in one call", func(t *testing.T) {
+		store := NewFileStore()
+		defer os.RemoveAll(store.Dir)
+		assert.NoError(t, store.Start(ctx))
+
+		assert.NoError(t, store.Set(ctx, "session", []byte("data")))
+		sessFile := filepath.Join(store.Dir, defaultFileStorePrefix+"session"+fileStoreSuffix)
+		oldTime := time.Now().Add(-2 * time.Hour)
+		assert.NoError(t, os.Chtimes(sessFile, oldTime, oldTime))
+
+		val, found, err := store.GetAndTouch(ctx, "session")
+		assert.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, []byte("data"), val)
+		info, err := os.Stat(sessFile)
+		assert.NoError(t, err)
+		assert.True(t, info.ModTime().After(oldTime))
+
+		val, found, err = store.GetAndTouch(ctx, "nope")
+		assert.NoError(t, err)
+		assert.False(t, found)
+		assert.Nil(t, val)
+
+		_, _, err = store.GetAndTouch(ctx, "../escape")
+		assert.Error(t, err)
+	})
+
+	t.Run("should compare and set versions", func(t *testing.T) {
+		store := &FileStore{Dir: t.TempDir()}
+		assert.NoError(t, store.Start(ctx))
+
+		version, err := store.CompareAndSet(ctx, "cas", []byte("one"), 0)
+		assert.NoError(t, err)
+		assert.NotZero(t, version)
+		_, err = store.CompareAndSet(ctx, "cas", []byte("two"), 0)
+		assert.ErrorIs(t, err, ErrVersionConflict)
+
+		val, current, found, err := store.GetVersioned(ctx, "cas")
+		assert.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, []byte("one"), val)
+		assert.Equal(t, version, current)
+
+		assert.NoError(t, store.Set(ctx, "cas", []byte("two")))
+		_, err = store.CompareAndSet(ctx, "cas", []byte("three"), version)
+		assert.ErrorIs(t, err, ErrVersionConflict, "Set changes the version")
+
+		_, _, found, err = store.GetVersioned(ctx, "nope")
+		assert.NoError(t, err)
+		assert.False(t, found)
+		_, err = store.CompareAndSet(ctx, "../escape", []byte("x"), 0)
+		assert.Error(t, err)
+	})
+
 	t.Run("should namespace files and reject unsafe IDs", func(t *testing.T) {
 		store := NewFileStore()
 		store.Prefix = "tenant_"
@@ -0,0 +1,99 @@
+package session
+
+import (
+	"context"
+	"html"
+	"html/template"
+	"strings"
+)
+
+// FlashSessionKey is the session key reserved for flash messages.
+const FlashSessionKey = "_httpok_flashes"
+
+// AddFlash adds msg to the flash messages of category in the session from
+// ctx. Flash messages are kept in the session until read by Flashes, so they
+// survive a redirect such as the one after a form post.
+func AddFlash(ctx context.Context, category, msg string) error {
+	sess, err := SessionFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	flashes, err := flashMessages(sess)
+	if err != nil {
+		return err
+	}
+	flashes[category] = append(flashes[category], msg)
+	return sess.Set(FlashSessionKey, flashes)
+}
+
+// Flashes returns and removes the flash messages of category from the
+// session in ctx. The session is changed only when there were messages to
+// return.
+func Flashes(ctx context.Context, category string) ([]string, error) {
+	sess, err := SessionFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	flashes, err := flashMessages(sess)
+	if err != nil {
+		return nil, err
+	}
+	messages, ok := flashes[category]
+	if !ok {
+		return nil, nil
+	}
+	delete(flashes, category)
+	if len(flashes) == 0 {
+		return messages, sess.Delete(FlashSessionKey)
+	}
+	return messages, sess.Set(FlashSessionKey, flashes)
+}
+
+// FlashesHTML returns and removes the flash messages of category, rendered
+// as an escaped list for templates:
+//
+//	<ul class="flashes flashes-error"><li>Invalid password</li></ul>
+//
+// It returns an empty value when there are no messages.
+func FlashesHTML(ctx context.Context, category string) template.HTML {
+	messages, err := Flashes(ctx, category)
+	if err != nil || len(messages) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString(`<ul class="flashes flashes-` + html.EscapeString(category) +
+		`">`)
+	for _, msg := range messages {
+		b.WriteString("<li>" + html.EscapeString(msg) + "</li>")
+	}
+	b.WriteString("</ul>")
+	return template.HTML(b.String())
+}
+
+// FlashFuncs returns template functions reading flash messages with the
+// request context:
+//
+//	{{ range flashes .Ctx "info" }}<p>{{ . }}</p>{{ end }}
+//	{{ flashesHTML .Ctx "error" }}
+func FlashFuncs() template.FuncMap {
+	return template.FuncMap{
+		"flashes": func(ctx context.Context, category string) []string {
+			messages, _ := Flashes(ctx, category)
+			return messages
+		},
+		"flashesHTML": FlashesHTML,
+	}
+}
+
+// flashMessages returns a copy of the flash messages in sess by category.
+func flashMessages(sess *Session) (map[string][]string, error) {
+	stored, err := GetAs[map[string][]string](sess, FlashSessionKey)
+	if err != nil {
+		return nil, err
+	}
+	flashes := make(map[string][]string, len(stored))
+	for category, messages := range stored {
+		flashes[category] = append([]string(nil), messages...)
+	}
+	return flashes, nil
+}
@@ -0,0 +1,44 @@
+package session
+
+import (
+	"context"
+	"html/template"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlashes(t *testing.T) {
+	sess := &Session{Id: "flash", Data: map[string]any{}}
+	ctx := context.WithValue(context.Background(), ContextSessValue, sess)
+
+	messages, err := Flashes(ctx, "info")
+	assert.NoError(t, err)
+	assert.Empty(t, messages)
+	assert.False(t, sess.Changed, "reading no flashes keeps the session")
+
+	assert.NoError(t, AddFlash(ctx, "info", "Saved"))
+	assert.NoError(t, AddFlash(ctx, "info", "Sent"))
+	assert.NoError(t, AddFlash(ctx, "error", "<b>Failed</b>"))
+	assert.True(t, sess.Changed)
+
+	messages, err = Flashes(ctx, "info")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Saved", "Sent"}, messages)
+	messages, err = Flashes(ctx, "info")
+	assert.NoError(t, err)
+	assert.Empty(t, messages, "flashes are read once")
+
+	assert.Equal(t, template.HTML(`<ul class="flashes flashes-error">`+
+		`<li>&lt;b&gt;Failed&lt;/b&gt;</li></ul>`), FlashesHTML(ctx, "error"))
+	assert.Empty(t, FlashesHTML(ctx, "error"))
+	assert.NotContains(t, sess.Data, FlashSessionKey)
+
+	assert.NoError(t, AddFlash(ctx, "info", "Welcome"))
+	tmpl := template.Must(template.New("page").Funcs(FlashFuncs()).Parse(
+		`{{ range flashes .Ctx "info" }}<p>{{ . }}</p>{{ end }}`))
+	var out strings.Builder
+	assert.NoError(t, tmpl.Execute(&out, map[string]any{"Ctx": ctx}))
+	assert.Equal(t, "<p>Welcome</p>", out.String())
+}
@@ -2,14 +2,18 @@ package session
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"maps"
 	"sync"
 	"time"
 )
 
-// memoryEntry stores session value and its last updated time.
+// memoryEntry stores session value, its version and its last updated time.
 type memoryEntry struct {
 	Value       []byte
+	Version     uint64
 	LastTouched time.Time
 }
 
@@ -80,6 +84,7 @@ func (s *MemoryStore) Set(ctx context.Context, id string, val []byte) error {
 	defer s.mu.Unlock()
 	s.Data[id] = memoryEntry{
 		Value:       val,
+		Version:     s.Data[id].Version + 1,
 		LastTouched: time.Now(),
 	}
 	return nil
@@ -122,3 +127,237 @@ func (s *MemoryStore) Touch(ctx context.Context, id string) error {
 	s.Data[id] = entry
 	return nil
 }
+
+// GetAndTouch returns the value for id and updates its LastTouched timestamp
+// under a single lock acquisition.
+func (s *MemoryStore) GetAndTouch(ctx context.Context, id string) ([]byte,
+	bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.Data[id]
+	if !ok {
+		return nil, false, nil
+	}
+	entry.LastTouched = time.Now()
+	s.Data[id] = entry
+	return entry.Value, true, nil
+}
+
+// GetVersioned returns the value and version for id and updates its
+// LastTouched timestamp.
+func (s *MemoryStore) GetVersioned(ctx context.Context, id string) ([]byte,
+	uint64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.Data[id]
+	if !ok {
+		return nil, 0, false, nil
+	}
+	entry.LastTouched = time.Now()
+	s.Data[id] = entry
+	return entry.Value, entry.Version, true, nil
+}
+
+// CompareAndSet stores val only when the current version of id equals
+// version, with version 0 requiring id to be absent. Versions count the
+// writes of an entry.
+func (s *MemoryStore) CompareAndSet(ctx context.Context, id string,
+	val []byte, version uint64) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Data[id].Version != version {
+		return 0, ErrVersionConflict
+	}
+	s.Data[id] = memoryEntry{
+		Value:       val,
+		Version:     version + 1,
+		LastTouched: time.Now(),
+	}
+	return version + 1, nil
+}
+
+// memoryPatchEntry stores a session as separate fields, or a value written
+// with Set, and its last updated time.
+type memoryPatchEntry struct {
+	Value       []byte
+	Fields      map[string][]byte
+	LastTouched time.Time
+}
+
+// MemoryPatchStore is a threadsafe in-memory PatchStore keeping every session
+// key as a separate field, so StoreEngine saves only the keys that changed.
+// It is suitable for testing or single-instance use.
+//
+// Get returns the fields of a session as a JSON object of the encoded
+// fields, and GetFields reads a value written with Set as one, so mixing
+// whole values with fields requires the JsonEncoder. Other values, such as
+// rate limit counters, are kept as they are.
+type MemoryPatchStore struct {
+	Data map[string]memoryPatchEntry
+	mu   sync.RWMutex
+}
+
+func NewMemoryPatchStore() *MemoryPatchStore {
+	return &MemoryPatchStore{
+		Data: map[string]memoryPatchEntry{},
+	}
+}
+
+// Start is a no-op for MemoryPatchStore.
+func (s *MemoryPatchStore) Start(ctx context.Context) error { return nil }
+
+// Stop is a no-op for MemoryPatchStore.
+func (s *MemoryPatchStore) Stop(ctx context.Context) error { return nil }
+
+// Delete removes any entry for the given id
+func (s *MemoryPatchStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.Data, id)
+	return nil
+}
+
+// Exists returns true if the id is present in the store
+func (s *MemoryPatchStore) Exists(ctx context.Context, id string) (bool,
+	error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.Data[id]
+	return ok, nil
+}
+
+// Get retrieves the value for the given id, encoding the fields of a
+// patched entry as a JSON object. Returns an error if not found.
+func (s *MemoryPatchStore) Get(ctx context.Context, id string) ([]byte,
+	error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	e, ok := s.Data[id]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	if e.Fields == nil {
+		return e.Value, nil
+	}
+	object := make(map[string]json.RawMessage, len(e.Fields))
+	for key, value := range e.Fields {
+		object[key] = value
+	}
+	return json.Marshal(object)
+}
+
+// GetString retrieves the string value for the given id.
+func (s *MemoryPatchStore) GetString(ctx context.Context, id string) (string,
+	error) {
+	val, err := s.Get(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	return string(val), nil
+}
+
+// Set saves or updates a value for the given id, replacing its fields and
+// updating the LastTouched time.
+func (s *MemoryPatchStore) Set(ctx context.Context, id string,
+	val []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Data[id] = memoryPatchEntry{Value: val, LastTouched: time.Now()}
+	return nil
+}
+
+// SetString stores a string value as bytes.
+func (s *MemoryPatchStore) SetString(ctx context.Context, id string,
+	val string) error {
+	return s.Set(ctx, id, []byte(val))
+}
+
+// Purge deletes all entries older than maxAge
+func (s *MemoryPatchStore) Purge(ctx context.Context,
+	maxAge time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, entry := range s.Data {
+		if time.Since(entry.LastTouched) > maxAge {
+			delete(s.Data, id)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryPatchStore) RequiresPurge() bool {
+	return true
+}
+
+// Touch updates the LastTouched timestamp for the entry identified by id.
+// Returns an error if the entry does not exist.
+func (s *MemoryPatchStore) Touch(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.Data[id]
+	if !ok {
+		return errors.New("not found")
+	}
+	entry.LastTouched = time.Now()
+	s.Data[id] = entry
+	return nil
+}
+
+// GetFields returns a copy of the fields of id and updates its LastTouched
+// timestamp.
+func (s *MemoryPatchStore) GetFields(ctx context.Context, id string) (
+	map[string][]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.Data[id]
+	if !ok {
+		return nil, false, nil
+	}
+	fields, err := entry.fields()
+	if err != nil {
+		return nil, false, err
+	}
+	entry.LastTouched = time.Now()
+	s.Data[id] = entry
+	return maps.Clone(fields), true, nil
+}
+
+// Patch sets the fields in set and removes the fields in deleted, creating
+// id when it does not exist, and updates its LastTouched timestamp.
+func (s *MemoryPatchStore) Patch(ctx context.Context, id string,
+	set map[string][]byte, deleted []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry := s.Data[id]
+	fields, err := entry.fields()
+	if err != nil {
+		return err
+	}
+	fields = maps.Clone(fields)
+	if fields == nil {
+		fields = map[string][]byte{}
+	}
+	maps.Copy(fields, set)
+	for _, key := range deleted {
+		delete(fields, key)
+	}
+	s.Data[id] = memoryPatchEntry{Fields: fields, LastTouched: time.Now()}
+	return nil
+}
+
+// fields returns the fields of the entry, reading a value written with Set
+// as a JSON object.
+func (e memoryPatchEntry) fields() (map[string][]byte, error) {
+	if e.Fields != nil || e.Value == nil {
+		return e.Fields, nil
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(e.Value, &object); err != nil {
+		return nil, fmt.Errorf("stored value is not a JSON object: %w", err)
+	}
+	fields := make(map[string][]byte, len(object))
+	for key, value := range object {
+		fields[key] = value
+	}
+	return fields, nil
+}
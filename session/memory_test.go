@@ -114,6 +114,58 @@ func TestMemorySessionStore(t *testing.T) {
 		assert.Error(t, err)
 	})
 
+	t.Run("should get and touch in one call", func(t *testing.T) {
+		store := NewMemoryStore()
+		store.Set(ctx, "session", []byte("data"))
+
+		store.mu.Lock()
+		oldTime := time.Now().Add(-2 * time.Hour)
+		entry := store.Data["session"]
+		entry.LastTouched = oldTime
+		store.Data["session"] = entry
+		store.mu.Unlock()
+
+		val, found, err := store.GetAndTouch(ctx, "session")
+		assert.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, []byte("data"), val)
+
+		store.mu.RLock()
+		assert.True(t, store.Data["session"].LastTouched.After(oldTime))
+		store.mu.RUnlock()
+
+		val, found, err = store.GetAndTouch(ctx, "nope")
+		assert.NoError(t, err)
+		assert.False(t, found)
+		assert.Nil(t, val)
+	})
+
+	t.Run("should compare and set versions", func(t *testing.T) {
+		store := NewMemoryStore()
+		version, err := store.CompareAndSet(ctx, "cas", []byte("one"), 0)
+		assert.NoError(t, err)
+		_, err = store.CompareAndSet(ctx, "cas", []byte("two"), 0)
+		assert.ErrorIs(t, err, ErrVersionConflict)
+
+		val, current, found, err := store.GetVersioned(ctx, "cas")
+		assert.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, []byte("one"), val)
+		assert.Equal(t, version, current)
+
+		assert.NoError(t, store.Set(ctx, "cas", []byte("two")))
+		_, err = store.CompareAndSet(ctx, "cas", []byte("three"), version)
+		assert.ErrorIs(t, err, ErrVersionConflict, "Set changes the version")
+
+		_, current, _, _ = store.GetVersioned(ctx, "cas")
+		_, err = store.CompareAndSet(ctx, "cas", []byte("three"), current)
+		assert.NoError(t, err)
+
+		_, _, found, err = store.GetVersioned(ctx, "nope")
+		assert.NoError(t, err)
+		assert.False(t, found)
+	})
+
 	t.Run("should handle concurrent access safely", func(t *testing.T) {
 		store := NewMemoryStore()
 		keys := []string{"a", "b", "c", "d", "e"}
@@ -143,3 +195,73 @@ func TestMemorySessionStore(t *testing.T) {
 
 	})
 }
+
+func TestMemoryPatchStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryPatchStore()
+
+	t.Run("should patch fields", func(t *testing.T) {
+		assert.NoError(t, store.Patch(ctx, "s1", map[string][]byte{
+			"a": []byte("1"), "b": []byte(`"two"`),
+		}, nil))
+		assert.NoError(t, store.Patch(ctx, "s1", map[string][]byte{
+			"c": []byte("true"),
+		}, []string{"a"}))
+
+		fields, ok, err := store.GetFields(ctx, "s1")
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, map[string][]byte{
+			"b": []byte(`"two"`), "c": []byte("true"),
+		}, fields)
+		fields["b"] = []byte("changed")
+		val, err := store.Get(ctx, "s1")
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"b":"two","c":true}`, string(val),
+			"fields are returned as a copy")
+
+		_, ok, err = store.GetFields(ctx, "missing")
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("should read values written with set", func(t *testing.T) {
+		assert.NoError(t, store.Set(ctx, "s2", []byte(`{"a":1,"b":[2]}`)))
+		assert.NoError(t, store.Patch(ctx, "s2", map[string][]byte{
+			"a": []byte("3"),
+		}, nil))
+		fields, ok, err := store.GetFields(ctx, "s2")
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, map[string][]byte{
+			"a": []byte("3"), "b": []byte("[2]"),
+		}, fields)
+
+		assert.NoError(t, store.SetString(ctx, "counter", "7"))
+		val, err := store.GetString(ctx, "counter")
+		assert.NoError(t, err)
+		assert.Equal(t, "7", val, "other values are kept as written")
+		_, _, err = store.GetFields(ctx, "counter")
+		assert.Error(t, err)
+	})
+
+	t.Run("should purge expired entries", func(t *testing.T) {
+		store := NewMemoryPatchStore()
+		assert.NoError(t, store.Patch(ctx, "old", nil, nil))
+		entry := store.Data["old"]
+		entry.LastTouched = time.Now().Add(-time.Hour)
+		store.Data["old"] = entry
+		assert.NoError(t, store.Patch(ctx, "new", nil, nil))
+
+		assert.NoError(t, store.Purge(ctx, time.Minute))
+		ok, err := store.Exists(ctx, "old")
+		assert.NoError(t, err)
+		assert.False(t, ok)
+		ok, err = store.Exists(ctx, "new")
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.NoError(t, store.Touch(ctx, "new"))
+		assert.NoError(t, store.Delete(ctx, "new"))
+		assert.Error(t, store.Touch(ctx, "new"))
+	})
+}
@@ -1,10 +1,13 @@
 package session
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
+	"reflect"
+	"sort"
 	"time"
 
 	"github.com/candango/httpok/logger"
@@ -61,6 +64,15 @@ type Engine interface {
 	DeleteSession(ctx context.Context, id string) error
 }
 
+// SessionFinder is an optional Engine capability loading an existing session
+// and refreshing its TTL without creating missing ones, so a middleware can
+// replace SessionExists followed by GetSession with a single call.
+type SessionFinder interface {
+	// FindSession returns the session with the given ID. ok is false, with
+	// a nil error, when the session does not exist.
+	FindSession(ctx context.Context, id string) (s Session, ok bool, err error)
+}
+
 // IdGenerator defines an interface for generating unique session IDs.
 // Implementations can provide different algorithms (e.g., UUID, random strings).
 type IdGenerator interface {
@@ -77,12 +89,23 @@ func EngineFromContext(ctx context.Context) (Engine, error) {
 	return s.(Engine), nil
 }
 
-// SessionFromContext retrieves the session from the context.
+// SessionLoader loads a session when it is first used. A middleware can store
+// one in the context under ContextSessValue instead of a *Session to avoid
+// contacting the store for requests that never use their session.
+type SessionLoader interface {
+	LoadSession() (*Session, error)
+}
+
+// SessionFromContext retrieves the session from the context, loading it
+// first when the context holds a SessionLoader.
 func SessionFromContext(ctx context.Context) (*Session, error) {
 	s := ctx.Value(ContextSessValue)
 	if s == nil {
 		return nil, errors.New("session value not found into the context")
 	}
+	if loader, ok := s.(SessionLoader); ok {
+		return loader.LoadSession()
+	}
 	sess, ok := s.(*Session)
 	if !ok {
 		return nil, errors.New("session value returned ins't a proper Session")
@@ -105,15 +128,28 @@ type EngineProperties struct {
 	CookieMaxAge     time.Duration
 	CookieKeyVersion *int
 	CookieOptions    *CookieOptions
+	// DataType is the struct type registered with WithDataType that Bind
+	// accepts.
+	DataType reflect.Type
+	// SaveRetries is how many times StoreEngine reloads and merges a session
+	// after a version conflict before failing the save. Zero, as left unset
+	// in WithProperties, uses the default of 3; a negative value disables
+	// retries.
+	SaveRetries int
 }
 
 // CookieOptions controls the transport attributes applied to session cookies.
 // The default options use HttpOnly and SameSite=Lax. Secure remains opt-in so
 // applications can run over plain HTTP during local development.
+//
+// AutoSecure marks the cookie Secure only for requests made over HTTPS, as
+// resolved by httpok.RequestScheme. Behind a reverse proxy that terminates
+// TLS, this requires the ProxyHeaders middleware with the proxy trusted.
 type CookieOptions struct {
-	HTTPOnly bool
-	Secure   bool
-	SameSite http.SameSite
+	HTTPOnly   bool
+	Secure     bool
+	AutoSecure bool
+	SameSite   http.SameSite
 }
 
 // Encoder is an interface for encoding and decoding session data.
@@ -149,11 +185,29 @@ type Session struct {
 	Data      map[string]any
 	Destroyed bool
 	Params    any
+	// loadedId, snapshot and version describe the stored session the data
+	// was loaded from: its ID, the encoded value of each key and, with a
+	// VersionedStore, its version. encoder encoded the snapshot.
+	loadedId string
+	snapshot map[string][]byte
+	version  uint64
+	encoder  Encoder
+	// dirty holds the keys changed by Set, Delete and Clear.
+	dirty map[string]struct{}
+	// bound is the struct returned by Bind, and boundAt the encoded value of
+	// each of its fields when it was last decoded from the data.
+	bound   any
+	boundAt map[string][]byte
 }
 
 // Clear removes all data from the session and marks it as changed.
 func (s *Session) Clear() {
+	for key := range s.Data {
+		s.markDirty(key)
+	}
 	s.Data = map[string]any{}
+	s.bound = nil
+	s.boundAt = nil
 	s.Changed = true
 }
 
@@ -162,12 +216,16 @@ func (s *Session) Delete(key string) error {
 	if s.Destroyed {
 		return sessionDestroyedError
 	}
+	if err := s.syncBound(); err != nil {
+		return err
+	}
 	_, ok := s.Data[key]
 	if ok {
 		delete(s.Data, key)
 	}
+	s.markDirty(key)
 	s.Changed = true
-	return nil
+	return s.rebind()
 }
 
 // Destroy clears the session data and marks it as destroyed.
@@ -188,11 +246,15 @@ func (s *Session) Get(key string) (any, error) {
 	if s.Destroyed {
 		return nil, sessionDestroyedError
 	}
-	data, ok := s.Data[key]
+	data, err := s.data()
+	if err != nil {
+		return nil, err
+	}
+	value, ok := data[key]
 	if !ok {
 		return nil, nil
 	}
-	return data, nil
+	return value, nil
 }
 
 // Has checks if a key exists in the session data.
@@ -200,16 +262,120 @@ func (s *Session) Has(key string) (bool, error) {
 	if s.Destroyed {
 		return false, sessionDestroyedError
 	}
-	_, ok := s.Data[key]
+	data, err := s.data()
+	if err != nil {
+		return false, err
+	}
+	_, ok := data[key]
 	return ok, nil
 }
 
+// Regenerate moves the session to a new ID from the Engine in the session
+// context, keeping its data, so an ID known before a privilege change such
+// as a login stops working. Sessioned sends the new cookie, stores the data
+// under the new ID and deletes the old entry when the handler returns.
+func (s *Session) Regenerate() error {
+	if s.Destroyed {
+		return sessionDestroyedError
+	}
+	if s.Ctx == nil {
+		return errors.New("session has no context to regenerate its id")
+	}
+	e, err := EngineFromContext(s.Ctx)
+	if err != nil {
+		return err
+	}
+	s.Id = e.NewId(s.Ctx)
+	s.Changed = true
+	return nil
+}
+
+// RegenerateEmpty moves the session to a new ID like Regenerate, discarding
+// its data.
+func (s *Session) RegenerateEmpty() error {
+	if s.Destroyed {
+		return sessionDestroyedError
+	}
+	s.Clear()
+	return s.Regenerate()
+}
+
 // Set adds or updates a key-value pair in the session data.
 func (s *Session) Set(key string, value any) error {
 	if s.Destroyed {
 		return sessionDestroyedError
 	}
+	if err := s.syncBound(); err != nil {
+		return err
+	}
 	s.Data[key] = value
+	s.markDirty(key)
 	s.Changed = true
-	return nil
+	return s.rebind()
+}
+
+// DirtyKeys returns the sorted keys changed by Set, Delete and Clear.
+func (s *Session) DirtyKeys() []string {
+	keys := make([]string, 0, len(s.dirty))
+	for key := range s.dirty {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Dirty reports whether the session has changes to save: keys changed by
+// Set, Delete and Clear or, for a session loaded by StoreEngine, values
+// modified in place, such as a nested map or slice, and fields changed through
+// the struct returned by Bind. In-place changes are detected by comparing the
+// data with the snapshot encoded at load, and the bound struct with its
+// encoding at bind time.
+func (s *Session) Dirty() bool {
+	if s.Changed {
+		return true
+	}
+	if s.snapshot == nil && s.bound == nil {
+		return false
+	}
+	set, deleted, err := s.changes(s.Id, s.encoderOrDefault())
+	return err != nil || len(set) > 0 || len(deleted) > 0
+}
+
+func (s *Session) markDirty(key string) {
+	if s.dirty == nil {
+		s.dirty = map[string]struct{}{}
+	}
+	s.dirty[key] = struct{}{}
+}
+
+// changes returns the encoded values of the keys set and the keys deleted
+// since the session was loaded, to be saved under id. Every key is set for a
+// session not loaded under id, such as a new or regenerated one.
+func (s *Session) changes(id string, enc Encoder) (set map[string][]byte,
+	deleted []string, err error) {
+	data, err := s.data()
+	if err != nil {
+		return nil, nil, err
+	}
+	loaded := s.snapshot != nil && id == s.loadedId
+	set = map[string][]byte{}
+	for key, value := range data {
+		encoded, err := enc.Encode(value)
+		if err != nil {
+			return nil, nil, err
+		}
+		_, marked := s.dirty[key]
+		if !loaded || marked || !bytes.Equal(encoded, s.snapshot[key]) {
+			set[key] = encoded
+		}
+	}
+	if loaded {
+		for key := range s.snapshot {
+			if _, ok := data[key]; !ok {
+				deleted = append(deleted, key)
+			}
+		}
+		sort.Strings(deleted)
+	}
+	return set, deleted, nil
 }
@@ -3,11 +3,15 @@ package session
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
+	"sort"
 	"time"
 
 	"github.com/candango/httpok/logger"
+	"github.com/candango/httpok/metrics"
 	"github.com/candango/httpok/security"
+	"github.com/candango/httpok/tracing"
 	scheduler "github.com/candango/schedulerok"
 )
 
@@ -66,6 +70,58 @@ type Store interface {
 	Touch(ctx context.Context, id string) error
 }
 
+// GetAndToucher is an optional Store capability fetching a value and
+// refreshing its TTL in one atomic operation. StoreEngine uses it when
+// available instead of separate Exists, Get and Touch calls.
+type GetAndToucher interface {
+	// GetAndTouch returns the value of id and refreshes its expiration/TTL.
+	// found is false, with a nil error, when id does not exist.
+	GetAndTouch(ctx context.Context, id string) (val []byte, found bool,
+		err error)
+}
+
+// ErrVersionConflict is returned by VersionedStore.CompareAndSet when the
+// stored value changed since the given version was read.
+var ErrVersionConflict = errors.New("session version conflict")
+
+// VersionedStore is an optional Store capability for optimistic concurrency.
+// Every stored value has a version that changes whenever the value is
+// written. StoreEngine loads sessions with GetVersioned and saves them with
+// CompareAndSet, so concurrent requests on one session do not overwrite each
+// other's changes.
+type VersionedStore interface {
+	// GetVersioned returns the value of id and its version and refreshes
+	// the expiration/TTL. found is false, with a nil error, when id does not
+	// exist.
+	GetVersioned(ctx context.Context, id string) (val []byte, version uint64,
+		found bool, err error)
+
+	// CompareAndSet stores val and refreshes the expiration/TTL only when the
+	// current version of id equals version, version 0 meaning that id must
+	// not exist. It returns the new version, or ErrVersionConflict.
+	CompareAndSet(ctx context.Context, id string, val []byte,
+		version uint64) (uint64, error)
+}
+
+// PatchStore is an optional Store capability keeping every session key as a
+// separately encoded field, like a Redis hash, so StoreEngine saves only the
+// keys that changed instead of rewriting the whole session. It takes
+// precedence over VersionedStore: saves touching different keys do not
+// overwrite each other. MemoryPatchStore implements it in memory, while
+// MemoryStore and FileStore keep whole encoded values.
+type PatchStore interface {
+	// GetFields returns the encoded fields of id and refreshes its
+	// expiration/TTL. found is false, with a nil error, when id does not
+	// exist.
+	GetFields(ctx context.Context, id string) (fields map[string][]byte,
+		found bool, err error)
+
+	// Patch sets the fields in set and removes the fields in deleted,
+	// creating id when it does not exist, and refreshes the expiration/TTL.
+	Patch(ctx context.Context, id string, set map[string][]byte,
+		deleted []string) error
+}
+
 type storeEngineOptions func(*StoreEngine)
 
 // StoreEngine implements the Engine interface by delegating session operations
@@ -81,6 +137,15 @@ type StoreEngine struct {
 	schedulerDone    chan error
 	schedulerOptions []scheduler.Option
 	started          bool
+	metrics          *engineMetrics
+}
+
+// engineMetrics holds the instruments registered by WithMetrics.
+type engineMetrics struct {
+	storeDuration *metrics.Histogram
+	storeErrors   *metrics.Counter
+	purgeRuns     *metrics.Counter
+	purgeDuration *metrics.Histogram
 }
 
 // NewStoreEngine creates and returns a new StoreEngine.
@@ -96,6 +161,7 @@ func NewStoreEngine(store Store, opts ...storeEngineOptions) *StoreEngine {
 			Name:          DefaultName,
 			Prefix:        DefaultPrefix,
 			PurgeDuration: 2 * time.Minute,
+			SaveRetries:   3,
 			CookieMaxAge:  31 * 24 * time.Hour,
 			CookieOptions: &CookieOptions{
 				HTTPOnly: true,
@@ -123,6 +189,34 @@ func WithLogger(l logger.Logger) storeEngineOptions {
 	}
 }
 
+// WithMetrics records store operation latency and errors, and purge runs and
+// duration, into registry:
+//
+//   - httpok_session_store_operation_duration_seconds by operation;
+//   - httpok_session_store_operation_errors_total by operation;
+//   - httpok_session_purge_runs_total by result (success or error);
+//   - httpok_session_purge_duration_seconds.
+func WithMetrics(registry *metrics.Registry) storeEngineOptions {
+	return func(e *StoreEngine) {
+		e.metrics = &engineMetrics{
+			storeDuration: registry.NewHistogram(
+				"httpok_session_store_operation_duration_seconds",
+				"Session store operation latency in seconds.", nil,
+				"operation"),
+			storeErrors: registry.NewCounter(
+				"httpok_session_store_operation_errors_total",
+				"Total number of failed session store operations.",
+				"operation"),
+			purgeRuns: registry.NewCounter(
+				"httpok_session_purge_runs_total",
+				"Total number of session purge runs.", "result"),
+			purgeDuration: registry.NewHistogram(
+				"httpok_session_purge_duration_seconds",
+				"Session purge duration in seconds.", nil),
+		}
+	}
+}
+
 func cloneCookieSecrets(secrets map[int][]byte) map[int][]byte {
 	clone := make(map[int][]byte, len(secrets))
 	for version, secret := range secrets {
@@ -154,6 +248,12 @@ func WithProperties(p *EngineProperties) storeEngineOptions {
 		if p.PurgeDuration != 0 {
 			e.Properties().PurgeDuration = p.PurgeDuration
 		}
+		if p.DataType != nil {
+			e.Properties().DataType = p.DataType
+		}
+		if p.SaveRetries != 0 {
+			e.Properties().SaveRetries = p.SaveRetries
+		}
 		if len(p.CookieSecret) != 0 {
 			e.Properties().CookieSecret = append([]byte(nil), p.CookieSecret...)
 		}
@@ -271,64 +371,202 @@ func (e *StoreEngine) Purge(ctx context.Context) error {
 	if e.properties.Enabled == nil || e.properties.Enabled == &pFalse {
 		return errors.New("engine is disabled")
 	}
-	return e.Store.Purge(ctx, e.properties.AgeLimit)
+	start := time.Now()
+	err := e.storeCall(ctx, "Purge", func(ctx context.Context) error {
+		return e.Store.Purge(ctx, e.properties.AgeLimit)
+	})
+	if e.metrics != nil {
+		result := "success"
+		if err != nil {
+			result = "error"
+		}
+		e.metrics.purgeRuns.Inc(result)
+		e.metrics.purgeDuration.Observe(time.Since(start).Seconds())
+	}
+	return err
 }
 
-// GetSession retrieves a session by ID and context.
-func (e *StoreEngine) GetSession(ctx context.Context, id string) (Session, error) {
-	s := Session{}
+// GetSession retrieves a session by ID and context, creating an empty one
+// when the ID does not exist.
+func (e *StoreEngine) GetSession(ctx context.Context, id string) (s Session, err error) {
+	spanCtx, span := tracing.StartSpan(ctx, "session.GetSession",
+		tracing.SpanKindInternal)
+	defer func() { span.RecordError(err); span.End() }()
+	s, ok, err := e.findSession(ctx, spanCtx, id)
+	if err != nil || ok {
+		return s, err
+	}
+	// TODO: I think we should only set Id and Data here
+	s = Session{
+		Id:       id,
+		Ctx:      ctx, // <=== THIS GUY SHOULD GO!!!
+		Data:     map[string]any{},
+		loadedId: id,
+		snapshot: map[string][]byte{},
+		encoder:  e.properties.Encoder,
+	}
+	if store, ok := e.Store.(PatchStore); ok {
+		err = e.storeCall(spanCtx, "Patch", func(ctx context.Context) error {
+			return store.Patch(ctx, id, nil, nil)
+		})
+		return s, err
+	}
+	data, err := e.properties.Encoder.Encode(map[string]any{})
+	if err != nil {
+		return s, err
+	}
+	store, versioned := e.Store.(VersionedStore)
+	if !versioned {
+		err = e.storeCall(spanCtx, "Set", func(ctx context.Context) error {
+			return e.Store.Set(ctx, id, data)
+		})
+		return s, err
+	}
+	err = e.storeCall(spanCtx, "CompareAndSet",
+		func(ctx context.Context) (err error) {
+			s.version, err = store.CompareAndSet(ctx, id, data, 0)
+			return err
+		})
+	if errors.Is(err, ErrVersionConflict) {
+		// A concurrent request created the session first.
+		s, ok, err = e.findSession(ctx, spanCtx, id)
+		if err == nil && !ok {
+			err = fmt.Errorf("%w: session was deleted", ErrVersionConflict)
+		}
+	}
+	return s, err
+}
+
+// FindSession retrieves an existing session by ID and refreshes its TTL.
+// Unlike GetSession it does not create missing sessions: ok is false when
+// the ID does not exist. With a GetAndToucher store this takes a single
+// store operation, so Sessioned uses it instead of SessionExists followed
+// by GetSession.
+func (e *StoreEngine) FindSession(ctx context.Context, id string) (s Session,
+	ok bool, err error) {
+	spanCtx, span := tracing.StartSpan(ctx, "session.FindSession",
+		tracing.SpanKindInternal)
+	defer func() { span.RecordError(err); span.End() }()
+	return e.findSession(ctx, spanCtx, id)
+}
+
+// findSession loads an existing session with the first capability the store
+// has among PatchStore, VersionedStore and GetAndToucher, and with Exists,
+// Get and Touch otherwise. The session keeps an encoded snapshot of each key
+// to detect changes at save. The session context is ctx, while store calls
+// are traced under spanCtx.
+func (e *StoreEngine) findSession(ctx, spanCtx context.Context,
+	id string) (s Session, ok bool, err error) {
 	pFalse := false
 	if e.properties.Enabled == nil || e.properties.Enabled == &pFalse {
-		return s, errors.New("engine is disabled")
+		return s, false, errors.New("engine is disabled")
 	}
 	if id == "" {
-		return s, errors.New("session id is empty")
+		return s, false, errors.New("session id is empty")
 	}
-	var v map[string]any
-	ok, err := e.Store.Exists(ctx, id)
-	if err != nil {
-		return s, err
+	s = Session{
+		Id:       id,
+		Ctx:      ctx,
+		loadedId: id,
+		encoder:  e.properties.Encoder,
 	}
-	if !ok {
-		data, err := e.properties.Encoder.Encode(map[string]any{})
-		if err != nil {
-			return s, err
+	if store, isPatch := e.Store.(PatchStore); isPatch {
+		err = e.storeCall(spanCtx, "GetFields",
+			func(ctx context.Context) (err error) {
+				s.snapshot, ok, err = store.GetFields(ctx, id)
+				return err
+			})
+		if err != nil || !ok {
+			return Session{}, false, err
+		}
+		s.Data = make(map[string]any, len(s.snapshot))
+		for key, encoded := range s.snapshot {
+			var value any
+			if err := e.properties.Encoder.Decode(encoded, &value); err != nil {
+				return Session{}, false, err
+			}
+			s.Data[key] = value
 		}
-		e.Store.Set(ctx, id, data)
+		return s, true, nil
 	}
-	data, err := e.Store.Get(ctx, id)
-	if err != nil {
-		return s, err
+	var data []byte
+	if store, isVersioned := e.Store.(VersionedStore); isVersioned {
+		err = e.storeCall(spanCtx, "GetVersioned",
+			func(ctx context.Context) (err error) {
+				data, s.version, ok, err = store.GetVersioned(ctx, id)
+				return err
+			})
+		if err != nil || !ok {
+			return Session{}, false, err
+		}
+	} else if store, isToucher := e.Store.(GetAndToucher); isToucher {
+		err = e.storeCall(spanCtx, "GetAndTouch",
+			func(ctx context.Context) (err error) {
+				data, ok, err = store.GetAndTouch(ctx, id)
+				return err
+			})
+		if err != nil || !ok {
+			return Session{}, false, err
+		}
+	} else {
+		err = e.storeCall(spanCtx, "Exists", func(ctx context.Context) (err error) {
+			ok, err = e.Store.Exists(ctx, id)
+			return err
+		})
+		if err != nil || !ok {
+			return Session{}, false, err
+		}
+		err = e.storeCall(spanCtx, "Get", func(ctx context.Context) (err error) {
+			data, err = e.Store.Get(ctx, id)
+			return err
+		})
+		if err != nil {
+			return Session{}, false, err
+		}
+		err = e.storeCall(spanCtx, "Touch", func(ctx context.Context) error {
+			return e.Store.Touch(ctx, id)
+		})
+		if err != nil {
+			return Session{}, false, err
+		}
 	}
-	err = e.Store.Touch(ctx, id)
+	err = e.properties.Encoder.Decode(data, &s.Data)
 	if err != nil {
-		return s, err
+		return Session{}, false, err
 	}
-	err = e.properties.Encoder.Decode(data, &v)
-	if err != nil {
-		return s, err
+	s.snapshot = make(map[string][]byte, len(s.Data))
+	for key, value := range s.Data {
+		if s.snapshot[key], err = e.properties.Encoder.Encode(value); err != nil {
+			return Session{}, false, err
+		}
 	}
-	// TODO: I think we should only set Id and Data here
-	return Session{
-		Id:        id,
-		Changed:   false,
-		Ctx:       ctx, // <=== THIS GUY SHOULD GO!!!
-		Data:      v,
-		Destroyed: false,
-	}, nil
+	return s, true, nil
 }
 
 // SessionExists checks if a session with the given ID exists.
-func (e *StoreEngine) SessionExists(ctx context.Context, id string) (bool, error) {
+func (e *StoreEngine) SessionExists(ctx context.Context, id string) (ok bool, err error) {
+	ctx, span := tracing.StartSpan(ctx, "session.SessionExists",
+		tracing.SpanKindInternal)
+	defer func() { span.RecordError(err); span.End() }()
 	pFalse := false
 	if e.properties.Enabled == nil || e.properties.Enabled == &pFalse {
 		return false, errors.New("engine is disabled")
 	}
-	return e.Store.Exists(ctx, id)
+	err = e.storeCall(ctx, "Exists", func(ctx context.Context) (err error) {
+		ok, err = e.Store.Exists(ctx, id)
+		return err
+	})
+	return ok, err
 }
 
-// SaveSession persists the session data for the given ID.
-func (e *StoreEngine) SaveSession(ctx context.Context, id string, session Session) error {
+// SaveSession persists the session data for the given ID. A session loaded by
+// GetSession or FindSession is saved with only its changes when the store
+// supports patches or versions, merging concurrent saves. Any other session,
+// such as one built by hand for an existing ID, overwrites the stored record.
+func (e *StoreEngine) SaveSession(ctx context.Context, id string, session Session) (err error) {
+	ctx, span := tracing.StartSpan(ctx, "session.SaveSession",
+		tracing.SpanKindInternal)
+	defer func() { span.RecordError(err); span.End() }()
 	pFalse := false
 	if e.properties.Enabled == nil || e.properties.Enabled == &pFalse {
 		return errors.New("engine is disabled")
@@ -337,16 +575,125 @@ func (e *StoreEngine) SaveSession(ctx context.Context, id string, session Sessio
 		return errors.New("session id is empty")
 	}
 
+	if err := session.syncBound(); err != nil {
+		return err
+	}
+	if store, ok := e.Store.(PatchStore); ok {
+		return e.savePatch(ctx, store, id, session)
+	}
+	if store, ok := e.Store.(VersionedStore); ok && session.loadedId != "" {
+		return e.saveVersioned(ctx, store, id, session)
+	}
 	data, err := e.properties.Encoder.Encode(session.Data)
 	if err != nil {
 		return err
 	}
-	return e.Store.Set(ctx, id, data)
+	return e.storeCall(ctx, "Set", func(ctx context.Context) error {
+		return e.Store.Set(ctx, id, data)
+	})
+}
+
+// saveVersioned saves session with CompareAndSet against the version it was
+// loaded with. On a conflict it reloads the stored data, reapplies the keys
+// the session set or deleted since it was loaded, and tries again up to
+// SaveRetries times. A session deleted in the meantime, as by a concurrent
+// logout, is not recreated.
+func (e *StoreEngine) saveVersioned(ctx context.Context, store VersionedStore,
+	id string, session Session) error {
+	loaded := id == session.loadedId
+	version := session.version
+	if !loaded {
+		// New and regenerated sessions must not overwrite an existing ID.
+		version = 0
+	}
+	data, err := e.properties.Encoder.Encode(session.Data)
+	if err != nil {
+		return err
+	}
+	var set map[string][]byte
+	var deleted []string
+	for attempt := 0; ; attempt++ {
+		err = e.storeCall(ctx, "CompareAndSet", func(ctx context.Context) error {
+			_, err := store.CompareAndSet(ctx, id, data, version)
+			return err
+		})
+		if !errors.Is(err, ErrVersionConflict) ||
+			attempt >= e.properties.SaveRetries {
+			return err
+		}
+		if set == nil {
+			set, deleted, err = session.changes(id, e.properties.Encoder)
+			if err != nil {
+				return err
+			}
+		}
+		var current []byte
+		var found bool
+		err = e.storeCall(ctx, "GetVersioned",
+			func(ctx context.Context) (err error) {
+				current, version, found, err = store.GetVersioned(ctx, id)
+				return err
+			})
+		if err != nil {
+			return err
+		}
+		if !found && loaded {
+			return fmt.Errorf("%w: session was deleted", ErrVersionConflict)
+		}
+		merged := map[string]any{}
+		if found {
+			if err := e.properties.Encoder.Decode(current, &merged); err != nil {
+				return err
+			}
+		}
+		for key := range set {
+			merged[key] = session.Data[key]
+		}
+		for _, key := range deleted {
+			delete(merged, key)
+		}
+		if data, err = e.properties.Encoder.Encode(merged); err != nil {
+			return err
+		}
+	}
+}
+
+// savePatch writes only the keys the session set or deleted since it was
+// loaded. A session not loaded through the engine replaces every stored
+// field.
+func (e *StoreEngine) savePatch(ctx context.Context, store PatchStore,
+	id string, session Session) error {
+	set, deleted, err := session.changes(id, e.properties.Encoder)
+	if err != nil {
+		return err
+	}
+	if session.loadedId == "" {
+		var stored map[string][]byte
+		err = e.storeCall(ctx, "GetFields", func(ctx context.Context) (err error) {
+			stored, _, err = store.GetFields(ctx, id)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for key := range stored {
+			if _, ok := set[key]; !ok {
+				deleted = append(deleted, key)
+			}
+		}
+		sort.Strings(deleted)
+	}
+	return e.storeCall(ctx, "Patch", func(ctx context.Context) error {
+		return store.Patch(ctx, id, set, deleted)
+	})
 }
 
 // DeleteSession invalidates the server-side session immediately. The store
 // decides whether physical cleanup is synchronous or deferred.
-func (e *StoreEngine) DeleteSession(ctx context.Context, id string) error {
+func (e *StoreEngine) DeleteSession(ctx context.Context, id string) (err error) {
+	ctx, span := tracing.StartSpan(ctx, "session.DeleteSession",
+		tracing.SpanKindInternal)
+	defer func() { span.RecordError(err); span.End() }()
 	pFalse := false
 	if e.properties.Enabled == nil || e.properties.Enabled == &pFalse {
 		return errors.New("engine is disabled")
@@ -354,5 +701,36 @@ func (e *StoreEngine) DeleteSession(ctx context.Context, id string) error {
 	if id == "" {
 		return errors.New("session id is empty")
 	}
-	return e.Store.Delete(ctx, id)
+	return e.storeCall(ctx, "Delete", func(ctx context.Context) error {
+		return e.Store.Delete(ctx, id)
+	})
+}
+
+// storeCall runs a Store operation. When ctx carries a tracing span, the
+// operation is recorded as a child span named after it, and its latency is
+// recorded when metrics are enabled. When the deadline of ctx has expired,
+// the store is not called and the context error is returned. A canceled ctx,
+// as when the client disconnects, does not stop the call, which runs without
+// the cancellation so the session is still saved.
+func (e *StoreEngine) storeCall(ctx context.Context, op string,
+	call func(context.Context) error) error {
+	ctx, span := tracing.StartSpan(ctx, "session.store."+op,
+		tracing.SpanKindInternal)
+	start := time.Now()
+	err := ctx.Err()
+	if errors.Is(err, context.Canceled) {
+		ctx, err = context.WithoutCancel(ctx), nil
+	}
+	if err == nil {
+		err = call(ctx)
+	}
+	if e.metrics != nil {
+		e.metrics.storeDuration.Observe(time.Since(start).Seconds(), op)
+		if err != nil {
+			e.metrics.storeErrors.Inc(op)
+		}
+	}
+	span.RecordError(err)
+	span.End()
+	return err
 }
@@ -2,9 +2,11 @@ package session
 
 import (
 	"context"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/candango/httpok/metrics"
 	scheduler "github.com/candango/schedulerok"
 	"github.com/candango/schedulerok/clocktest"
 	"github.com/stretchr/testify/assert"
@@ -219,3 +221,353 @@ func TestStoreEngineWithPropertiesMerge(t *testing.T) {
 	WithProperties(update)(s)
 	assert.Equal(t, time.Duration(7200), s.Properties().AgeLimit, "non-zero should overwrite")
 }
+
+func TestStoreEngineMetrics(t *testing.T) {
+	ctx := context.Background()
+	registry := metrics.NewRegistry()
+	engine := NewStoreEngine(NewMemoryStore(), WithMetrics(registry))
+
+	_, err := engine.GetSession(ctx, "metrics-test")
+	assert.NoError(t, err)
+	assert.NoError(t, engine.Purge(ctx))
+
+	var out strings.Builder
+	assert.NoError(t, registry.WriteText(&out))
+	text := out.String()
+	for _, op := range []string{"GetVersioned", "CompareAndSet", "Purge"} {
+		assert.Contains(t, text, `httpok_session_store_operation_duration_`+
+			`seconds_count{operation="`+op+`"} 1`)
+	}
+	assert.Contains(t, text, `httpok_session_purge_runs_total{result="success"} 1`)
+	assert.Contains(t, text, "httpok_session_purge_duration_seconds_count 1\n")
+}
+
+func TestStoreEngineFindSession(t *testing.T) {
+	ctx := context.Background()
+	touchOnly := NewMemoryStore()
+	for name, tc := range map[string]struct {
+		store Store
+		ops   []string
+	}{
+		"VersionedStore": {NewMemoryStore(), []string{"GetVersioned"}},
+		// Embedding interfaces hides the other capabilities from the engine.
+		"GetAndToucher": {struct {
+			Store
+			GetAndToucher
+		}{touchOnly, touchOnly}, []string{"GetAndTouch"}},
+		"fallback": {struct{ Store }{NewMemoryStore()},
+			[]string{"Exists", "Get", "Touch"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			registry := metrics.NewRegistry()
+			engine := NewStoreEngine(tc.store, WithMetrics(registry))
+
+			_, ok, err := engine.FindSession(ctx, "missing")
+			assert.NoError(t, err)
+			assert.False(t, ok)
+			exists, err := engine.SessionExists(ctx, "missing")
+			assert.NoError(t, err)
+			assert.False(t, exists, "FindSession does not create sessions")
+
+			assert.NoError(t, engine.SaveSession(ctx, "found", Session{
+				Data: map[string]any{"key": "value"},
+			}))
+			sess, ok, err := engine.FindSession(ctx, "found")
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, "found", sess.Id)
+			assert.Equal(t, "value", sess.Data["key"])
+
+			var out strings.Builder
+			assert.NoError(t, registry.WriteText(&out))
+			for _, op := range tc.ops {
+				assert.Contains(t, out.String(), `operation="`+op+`"`)
+			}
+		})
+	}
+}
+
+// conflictingStore reports a version conflict on every CompareAndSet.
+type conflictingStore struct {
+	*MemoryStore
+	calls int
+}
+
+func (s *conflictingStore) CompareAndSet(context.Context, string, []byte,
+	uint64) (uint64, error) {
+	s.calls++
+	return 0, ErrVersionConflict
+}
+
+func TestStoreEngineMergesConcurrentSaves(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range map[string]Store{
+		"MemoryStore": NewMemoryStore(),
+		"FileStore":   &FileStore{Dir: t.TempDir()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			engine := NewStoreEngine(store)
+			sess, err := engine.GetSession(ctx, "shared")
+			assert.NoError(t, err)
+			sess.Data = map[string]any{"a": 1, "b": 1, "c": 1}
+			assert.NoError(t, engine.SaveSession(ctx, "shared", sess))
+
+			first, err := engine.GetSession(ctx, "shared")
+			assert.NoError(t, err)
+			second, err := engine.GetSession(ctx, "shared")
+			assert.NoError(t, err)
+			assert.NoError(t, first.Set("a", 2))
+			assert.NoError(t, first.Delete("c"))
+			assert.NoError(t, second.Set("b", 2))
+			assert.NoError(t, engine.SaveSession(ctx, "shared", first))
+			assert.NoError(t, engine.SaveSession(ctx, "shared", second))
+
+			merged, err := engine.GetSession(ctx, "shared")
+			assert.NoError(t, err)
+			assert.Equal(t, map[string]any{"a": float64(2), "b": float64(2)},
+				merged.Data)
+
+			assert.NoError(t, engine.DeleteSession(ctx, "shared"))
+			assert.NoError(t, merged.Set("a", 3))
+			assert.ErrorIs(t, engine.SaveSession(ctx, "shared", merged),
+				ErrVersionConflict)
+			exists, err := engine.SessionExists(ctx, "shared")
+			assert.NoError(t, err)
+			assert.False(t, exists, "deleted sessions are not recreated")
+		})
+	}
+
+	t.Run("retries", func(t *testing.T) {
+		store := &conflictingStore{MemoryStore: NewMemoryStore()}
+		engine := NewStoreEngine(store, WithProperties(&EngineProperties{
+			SaveRetries: 2,
+		}))
+		assert.NoError(t, store.Set(ctx, "busy", []byte("{}")))
+		sess, err := engine.GetSession(ctx, "busy")
+		assert.NoError(t, err)
+		assert.NoError(t, sess.Set("key", "value"))
+		err = engine.SaveSession(ctx, "busy", sess)
+		assert.ErrorIs(t, err, ErrVersionConflict)
+		assert.Equal(t, 3, store.calls)
+	})
+
+	t.Run("no retries", func(t *testing.T) {
+		store := &conflictingStore{MemoryStore: NewMemoryStore()}
+		engine := NewStoreEngine(store, WithProperties(&EngineProperties{
+			SaveRetries: -1,
+		}))
+		assert.NoError(t, store.Set(ctx, "busy", []byte("{}")))
+		sess, err := engine.GetSession(ctx, "busy")
+		assert.NoError(t, err)
+		assert.NoError(t, sess.Set("key", "value"))
+		err = engine.SaveSession(ctx, "busy", sess)
+		assert.ErrorIs(t, err, ErrVersionConflict)
+		assert.Equal(t, 1, store.calls)
+	})
+}
+
+func TestStoreEngineOverwritesUnloadedSessions(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range map[string]Store{
+		"MemoryStore":      NewMemoryStore(),
+		"MemoryPatchStore": NewMemoryPatchStore(),
+		"FileStore":        &FileStore{Dir: t.TempDir()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			engine := NewStoreEngine(store)
+			sess, err := engine.GetSession(ctx, "manual")
+			assert.NoError(t, err)
+			sess.Data = map[string]any{"a": 1, "b": 1}
+			assert.NoError(t, engine.SaveSession(ctx, "manual", sess))
+
+			assert.NoError(t, engine.SaveSession(ctx, "manual", Session{
+				Data: map[string]any{"a": 2},
+			}))
+			sess, err = engine.GetSession(ctx, "manual")
+			assert.NoError(t, err)
+			assert.Equal(t, map[string]any{"a": 2.0}, sess.Data,
+				"keys left out of a session built by hand are not kept")
+		})
+	}
+}
+
+// fieldStore is a PatchStore keeping session fields in memory.
+type fieldStore struct {
+	*MemoryStore
+	fields  map[string]map[string][]byte
+	patches []map[string][]byte
+	deletes [][]string
+}
+
+func (s *fieldStore) GetFields(_ context.Context, id string) (
+	map[string][]byte, bool, error) {
+	fields, ok := s.fields[id]
+	return fields, ok, nil
+}
+
+func (s *fieldStore) Patch(_ context.Context, id string,
+	set map[string][]byte, deleted []string) error {
+	if s.fields[id] == nil {
+		s.fields[id] = map[string][]byte{}
+	}
+	for key, value := range set {
+		s.fields[id][key] = value
+	}
+	for _, key := range deleted {
+		delete(s.fields[id], key)
+	}
+	s.patches = append(s.patches, set)
+	s.deletes = append(s.deletes, deleted)
+	return nil
+}
+
+func TestSessionDirty(t *testing.T) {
+	ctx := context.Background()
+	engine := NewStoreEngine(NewMemoryStore())
+	sess, err := engine.GetSession(ctx, "dirty-test")
+	assert.NoError(t, err)
+	assert.False(t, sess.Dirty())
+	sess.Data["cart"] = map[string]any{"items": []any{"book"}}
+	assert.True(t, sess.Dirty(), "direct map changes are detected")
+	assert.Empty(t, sess.DirtyKeys())
+	assert.NoError(t, engine.SaveSession(ctx, sess.Id, sess))
+
+	sess, err = engine.GetSession(ctx, "dirty-test")
+	assert.NoError(t, err)
+	assert.False(t, sess.Dirty())
+	cart := sess.Data["cart"].(map[string]any)
+	cart["items"] = append(cart["items"].([]any), "pen")
+	assert.True(t, sess.Dirty(), "nested changes are detected")
+
+	assert.NoError(t, sess.Set("b", 1))
+	assert.NoError(t, sess.Delete("a"))
+	assert.Equal(t, []string{"a", "b"}, sess.DirtyKeys())
+}
+
+func TestStoreEnginePatchesChangedKeys(t *testing.T) {
+	ctx := context.Background()
+	store := &fieldStore{MemoryStore: NewMemoryStore(),
+		fields: map[string]map[string][]byte{}}
+	engine := NewStoreEngine(store)
+
+	sess, err := engine.GetSession(ctx, "patch-test")
+	assert.NoError(t, err)
+	assert.NoError(t, sess.Set("profile", map[string]any{"name": "Ada"}))
+	assert.NoError(t, sess.Set("counter", 1))
+	assert.NoError(t, sess.Set("old", true))
+	assert.NoError(t, engine.SaveSession(ctx, sess.Id, sess))
+
+	sess, err = engine.GetSession(ctx, "patch-test")
+	assert.NoError(t, err)
+	assert.Equal(t, "Ada", sess.Data["profile"].(map[string]any)["name"])
+	assert.NoError(t, sess.Set("counter", 2))
+	assert.NoError(t, sess.Delete("old"))
+	assert.NoError(t, engine.SaveSession(ctx, sess.Id, sess))
+
+	last := len(store.patches) - 1
+	assert.Equal(t, map[string][]byte{"counter": []byte("2")},
+		store.patches[last], "unchanged keys are not rewritten")
+	assert.Equal(t, []string{"old"}, store.deletes[last])
+
+	sess, err = engine.GetSession(ctx, "patch-test")
+	assert.NoError(t, err)
+	sess.Data["profile"].(map[string]any)["name"] = "Grace"
+	assert.True(t, sess.Dirty())
+	assert.NoError(t, engine.SaveSession(ctx, sess.Id, sess))
+	last = len(store.patches) - 1
+	assert.Equal(t, map[string][]byte{"profile": []byte(`{"name":"Grace"}`)},
+		store.patches[last])
+	assert.Empty(t, store.deletes[last])
+}
+
+func TestStoreEngineWithMemoryPatchStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryPatchStore()
+	engine := NewStoreEngine(store)
+	sess, err := engine.GetSession(ctx, "patched")
+	assert.NoError(t, err)
+	assert.NoError(t, sess.Set("profile", map[string]any{"name": "Ada"}))
+	assert.NoError(t, sess.Set("counter", 1))
+	assert.NoError(t, sess.Set("old", true))
+	assert.NoError(t, engine.SaveSession(ctx, sess.Id, sess))
+
+	first, err := engine.GetSession(ctx, "patched")
+	assert.NoError(t, err)
+	second, err := engine.GetSession(ctx, "patched")
+	assert.NoError(t, err)
+	assert.NoError(t, first.Set("counter", 2))
+	assert.NoError(t, first.Delete("old"))
+	assert.NoError(t, second.Set("theme", "dark"))
+	store.Data["patched"].Fields["profile"] = []byte(`{"name":"Grace"}`)
+	assert.NoError(t, engine.SaveSession(ctx, "patched", first))
+	assert.NoError(t, engine.SaveSession(ctx, "patched", second))
+
+	fields, ok, err := store.GetFields(ctx, "patched")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, map[string][]byte{
+		"counter": []byte("2"),
+		"profile": []byte(`{"name":"Grace"}`),
+		"theme":   []byte(`"dark"`),
+	}, fields, "unchanged keys are not rewritten")
+}
+
+func TestStoreEngineSkipsStoreWhenContextDone(t *testing.T) {
+	store := NewMemoryStore()
+	engine := NewStoreEngine(store)
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now())
+	defer cancel()
+
+	_, err := engine.GetSession(ctx, "done-test")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	err = engine.SaveSession(ctx, "done-test", Session{
+		Data: map[string]any{"key": "value"},
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	exists, err := engine.SessionExists(context.Background(), "done-test")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestStoreEngineSavesWhenContextCanceled(t *testing.T) {
+	engine := NewStoreEngine(NewMemoryStore())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	sess, err := engine.GetSession(ctx, "canceled-test")
+	assert.NoError(t, err)
+	assert.NoError(t, sess.Set("key", "value"))
+	assert.NoError(t, engine.SaveSession(ctx, sess.Id, sess))
+
+	sess, err = engine.GetSession(context.Background(), "canceled-test")
+	assert.NoError(t, err)
+	value, err := sess.Get("key")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", value, "saved after the client went away")
+}
+
+func TestSessionRegenerate(t *testing.T) {
+	engine := NewStoreEngine(NewMemoryStore())
+	ctx := context.WithValue(context.Background(), ContextEngValue, engine)
+	sess, err := engine.GetSession(ctx, "regenerate-test")
+	assert.NoError(t, err)
+	assert.NoError(t, sess.Set("key", "value"))
+
+	assert.NoError(t, sess.Regenerate())
+	assert.NotEqual(t, "regenerate-test", sess.Id)
+	assert.True(t, sess.Changed)
+	value, err := sess.Get("key")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", value)
+
+	id := sess.Id
+	assert.NoError(t, sess.RegenerateEmpty())
+	assert.NotEqual(t, id, sess.Id)
+	assert.Empty(t, sess.Data)
+
+	orphan := Session{Id: "orphan", Ctx: context.Background()}
+	assert.Error(t, orphan.Regenerate(), "no engine in the context")
+	assert.NoError(t, sess.Destroy())
+	assert.Error(t, sess.Regenerate())
+}
@@ -0,0 +1,195 @@
+package session
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"reflect"
+)
+
+// GetAs returns the value of key converted to T. Values set during the
+// request keep their type, while values loaded from the store come back in
+// the encoder's generic form, such as float64 numbers and maps from JSON.
+// Those are converted by encoding them and decoding the result into T with
+// the session encoder, which restores int, time.Time and struct types. A
+// missing key returns the zero value of T and a nil error, like Get.
+func GetAs[T any](s *Session, key string) (T, error) {
+	var v T
+	value, err := s.Get(key)
+	if err != nil || value == nil {
+		return v, err
+	}
+	if typed, ok := value.(T); ok {
+		return typed, nil
+	}
+	if err := convert(s.encoderOrDefault(), value, &v); err != nil {
+		return v, fmt.Errorf("session key %q: %w", key, err)
+	}
+	return v, nil
+}
+
+// Bind returns the session data bound to the struct type T. The first call
+// decodes the session data into a new T, and later calls return the same
+// pointer. Get, Has and GetAs read its fields under the keys named after the
+// encoded struct fields, and fields changed through it are written back to
+// those keys when the session is saved. Fields left unchanged are not, so
+// binding alone does not change the session. Set and Delete on a bound
+// session also update the struct, a deleted key resetting its field to the
+// zero value without storing it. Keys outside the struct, such as the CSRF
+// secret or the login principal, are kept.
+//
+// When the engine in the session context registers a type with
+// WithDataType, Bind fails for any other T.
+func Bind[T any](s *Session) (*T, error) {
+	if s.Destroyed {
+		return nil, sessionDestroyedError
+	}
+	if bound, ok := s.bound.(*T); ok {
+		return bound, nil
+	}
+	if s.bound != nil {
+		return nil, fmt.Errorf("session is bound to %T", s.bound)
+	}
+	want := reflect.TypeFor[T]()
+	if registered := s.dataType(); registered != nil && registered != want {
+		return nil, fmt.Errorf("session data type is %s, not %s", registered,
+			want)
+	}
+	v := new(T)
+	s.bound = v
+	if err := s.rebind(); err != nil {
+		s.bound = nil
+		return nil, err
+	}
+	return v, nil
+}
+
+// WithDataType registers T as the struct type the data of every session of
+// the engine binds to with Bind.
+func WithDataType[T any]() storeEngineOptions {
+	return func(e *StoreEngine) {
+		e.Properties().DataType = reflect.TypeFor[T]()
+	}
+}
+
+// syncBound writes the changed fields of the bound struct back to the
+// session data. SaveSession, Set and Delete call it.
+func (s *Session) syncBound() error {
+	fields, err := s.boundFields()
+	if err != nil {
+		return err
+	}
+	for key, value := range fields {
+		s.Data[key] = value
+	}
+	return nil
+}
+
+// rebind decodes the session data into the bound struct, when binding it and
+// after Set or Delete changed a key, and records the encoding of its fields
+// to tell later changes apart.
+func (s *Session) rebind() error {
+	if s.bound == nil {
+		return nil
+	}
+	reflect.ValueOf(s.bound).Elem().SetZero()
+	if err := convert(s.encoderOrDefault(), s.Data, s.bound); err != nil {
+		return err
+	}
+	_, encoded, err := s.encodeBound()
+	if err != nil {
+		return err
+	}
+	s.boundAt = encoded
+	return nil
+}
+
+// boundFields returns the fields of the bound struct changed since it was
+// last decoded, as session keys, or nil when no struct is bound. Unchanged
+// fields are left out, so the zero value of a field missing from the data,
+// as after Delete, is not written.
+func (s *Session) boundFields() (map[string]any, error) {
+	if s.bound == nil || s.Destroyed {
+		return nil, nil
+	}
+	fields, encoded, err := s.encodeBound()
+	if err != nil {
+		return nil, err
+	}
+	for key := range fields {
+		if at, ok := s.boundAt[key]; ok && bytes.Equal(encoded[key], at) {
+			delete(fields, key)
+		}
+	}
+	return fields, nil
+}
+
+// encodeBound returns the fields of the bound struct as session keys and the
+// encoded value of each.
+func (s *Session) encodeBound() (map[string]any, map[string][]byte, error) {
+	enc := s.encoderOrDefault()
+	fields := map[string]any{}
+	if err := convert(enc, s.bound, &fields); err != nil {
+		return nil, nil, err
+	}
+	encoded := make(map[string][]byte, len(fields))
+	for key, value := range fields {
+		data, err := enc.Encode(value)
+		if err != nil {
+			return nil, nil, err
+		}
+		encoded[key] = data
+	}
+	return fields, encoded, nil
+}
+
+// data returns the session data with the fields of the bound struct applied,
+// leaving Data unchanged.
+func (s *Session) data() (map[string]any, error) {
+	fields, err := s.boundFields()
+	if err != nil || len(fields) == 0 {
+		return s.Data, err
+	}
+	data := maps.Clone(s.Data)
+	if data == nil {
+		data = map[string]any{}
+	}
+	maps.Copy(data, fields)
+	return data, nil
+}
+
+// encoderOrDefault returns the encoder the session was loaded with, or the
+// one of the engine in the session context, or a JsonEncoder.
+func (s *Session) encoderOrDefault() Encoder {
+	if s.encoder != nil {
+		return s.encoder
+	}
+	if s.Ctx != nil {
+		if e, err := EngineFromContext(s.Ctx); err == nil &&
+			e.Properties().Encoder != nil {
+			return e.Properties().Encoder
+		}
+	}
+	return &JsonEncoder{}
+}
+
+// dataType returns the type registered by the engine in the session context.
+func (s *Session) dataType() reflect.Type {
+	if s.Ctx == nil {
+		return nil
+	}
+	e, err := EngineFromContext(s.Ctx)
+	if err != nil {
+		return nil
+	}
+	return e.Properties().DataType
+}
+
+// convert encodes value and decodes the result into target.
+func convert(enc Encoder, value any, target any) error {
+	data, err := enc.Encode(value)
+	if err != nil {
+		return err
+	}
+	return enc.Decode(data, target)
+}
@@ -0,0 +1,190 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// numberEncoder decodes JSON numbers as json.Number.
+type numberEncoder struct {
+	JsonEncoder
+}
+
+func (e *numberEncoder) Decode(data []byte, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+type typedProfile struct {
+	Name  string    `json:"name"`
+	Since time.Time `json:"since"`
+}
+
+type typedCart struct {
+	Count   int            `json:"count"`
+	Items   []string       `json:"items"`
+	Profile typedProfile   `json:"profile"`
+	Prices  map[string]int `json:"prices"`
+}
+
+func TestGetAs(t *testing.T) {
+	ctx := context.Background()
+	since := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for name, encoder := range map[string]Encoder{
+		"JsonEncoder":   &JsonEncoder{},
+		"numberEncoder": &numberEncoder{},
+	} {
+		t.Run(name, func(t *testing.T) {
+			engine := NewStoreEngine(NewMemoryStore(),
+				WithProperties(&EngineProperties{Encoder: encoder}))
+			sess, err := engine.GetSession(ctx, "typed")
+			assert.NoError(t, err)
+			assert.NoError(t, sess.Set("count", 3))
+			assert.NoError(t, sess.Set("since", since))
+			assert.NoError(t, sess.Set("profile",
+				typedProfile{Name: "Ada", Since: since}))
+			assert.NoError(t, sess.Set("text", "three"))
+
+			count, err := GetAs[int](&sess, "count")
+			assert.NoError(t, err)
+			assert.Equal(t, 3, count, "values set in the request")
+
+			assert.NoError(t, engine.SaveSession(ctx, sess.Id, sess))
+			sess, err = engine.GetSession(ctx, "typed")
+			assert.NoError(t, err)
+
+			count, err = GetAs[int](&sess, "count")
+			assert.NoError(t, err)
+			assert.Equal(t, 3, count)
+			at, err := GetAs[time.Time](&sess, "since")
+			assert.NoError(t, err)
+			assert.True(t, since.Equal(at))
+			profile, err := GetAs[typedProfile](&sess, "profile")
+			assert.NoError(t, err)
+			assert.Equal(t, "Ada", profile.Name)
+			assert.True(t, since.Equal(profile.Since))
+
+			missing, err := GetAs[int](&sess, "missing")
+			assert.NoError(t, err)
+			assert.Zero(t, missing)
+			_, err = GetAs[int](&sess, "text")
+			assert.Error(t, err)
+			assert.False(t, sess.Dirty(), "GetAs does not change the data")
+		})
+	}
+}
+
+func TestBind(t *testing.T) {
+	engine := NewStoreEngine(NewMemoryStore(), WithDataType[typedCart]())
+	ctx := context.WithValue(context.Background(), ContextEngValue, engine)
+	sess, err := engine.GetSession(ctx, "bound")
+	assert.NoError(t, err)
+	assert.NoError(t, sess.Set("csrf", "secret"))
+
+	cart, err := Bind[typedCart](&sess)
+	assert.NoError(t, err)
+	assert.Zero(t, cart.Count)
+	cart.Count = 2
+	cart.Items = append(cart.Items, "book", "pen")
+	cart.Profile.Since = time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	again, err := Bind[typedCart](&sess)
+	assert.NoError(t, err)
+	assert.Same(t, cart, again)
+	_, err = Bind[typedProfile](&sess)
+	assert.Error(t, err, "another type than the bound one")
+	assert.NoError(t, engine.SaveSession(ctx, sess.Id, sess))
+
+	sess, err = engine.GetSession(ctx, "bound")
+	assert.NoError(t, err)
+	_, err = Bind[typedProfile](&sess)
+	assert.Error(t, err, "another type than the registered one")
+	cart, err = Bind[typedCart](&sess)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, cart.Count)
+	assert.Equal(t, []string{"book", "pen"}, cart.Items)
+	assert.Equal(t, 2024, cart.Profile.Since.Year())
+	csrf, err := GetAs[string](&sess, "csrf")
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", csrf, "keys outside the struct are kept")
+
+	assert.False(t, sess.Dirty())
+	cart.Count++
+	count, err := GetAs[int](&sess, "count")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count, "reads see the bound struct")
+	assert.Equal(t, 2.0, sess.Data["count"], "GetAs does not write Data")
+	assert.True(t, sess.Dirty(), "changes to the bound struct are saved")
+	assert.Equal(t, 2.0, sess.Data["count"], "Dirty does not write Data")
+
+	assert.NoError(t, sess.Set("count", 7))
+	assert.Equal(t, 7, cart.Count, "Set updates the bound struct")
+	assert.Equal(t, []string{"book", "pen"}, cart.Items)
+	assert.NoError(t, engine.SaveSession(ctx, sess.Id, sess))
+	sess, err = engine.GetSession(ctx, "bound")
+	assert.NoError(t, err)
+	count, err = GetAs[int](&sess, "count")
+	assert.NoError(t, err)
+	assert.Equal(t, 7, count)
+
+	cart, err = Bind[typedCart](&sess)
+	assert.NoError(t, err)
+	cart.Items = append(cart.Items, "ink")
+	assert.NoError(t, sess.Delete("count"))
+	assert.Zero(t, cart.Count, "Delete resets the bound field")
+	assert.Equal(t, []string{"book", "pen", "ink"}, cart.Items,
+		"struct changes made before Delete are kept")
+	has, err := sess.Has("count")
+	assert.NoError(t, err)
+	assert.False(t, has, "the deleted value is not restored from the struct")
+	assert.NoError(t, engine.SaveSession(ctx, sess.Id, sess))
+	stored, err := engine.Store.Get(ctx, "bound")
+	assert.NoError(t, err)
+	var payload map[string]any
+	assert.NoError(t, json.Unmarshal(stored, &payload))
+	assert.NotContains(t, payload, "count")
+	assert.Contains(t, payload, "items")
+	sess, err = engine.GetSession(ctx, "bound")
+	assert.NoError(t, err)
+	has, err = sess.Has("count")
+	assert.NoError(t, err)
+	assert.False(t, has)
+	items, err := GetAs[[]string](&sess, "items")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"book", "pen", "ink"}, items)
+	cart, err = Bind[typedCart](&sess)
+	assert.NoError(t, err)
+
+	sess.Clear()
+	assert.Empty(t, sess.Data)
+	assert.NoError(t, sess.syncBound())
+	assert.Empty(t, sess.Data, "Clear unbinds the struct")
+}
+
+func TestBindDoesNotChangeSession(t *testing.T) {
+	engine := NewStoreEngine(NewMemoryStore(), WithDataType[typedCart]())
+	ctx := context.WithValue(context.Background(), ContextEngValue, engine)
+	sess, err := engine.GetSession(ctx, "unchanged")
+	assert.NoError(t, err)
+
+	cart, err := Bind[typedCart](&sess)
+	assert.NoError(t, err)
+	assert.False(t, sess.Dirty(), "binding a new session")
+	has, err := sess.Has("count")
+	assert.NoError(t, err)
+	assert.False(t, has, "zero fields are not added to the data")
+
+	cart.Count = 1
+	assert.True(t, sess.Dirty())
+	assert.NoError(t, engine.SaveSession(ctx, sess.Id, sess))
+	sess, err = engine.GetSession(ctx, "unchanged")
+	assert.NoError(t, err)
+	_, err = Bind[typedCart](&sess)
+	assert.NoError(t, err)
+	assert.False(t, sess.Dirty(), "binding a loaded session")
+}
@@ -0,0 +1,72 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+// Exporter receives finished spans.
+type Exporter interface {
+	ExportSpan(ctx context.Context, span SpanData) error
+}
+
+// WriterExporter writes finished spans as JSON lines to a writer.
+type WriterExporter struct {
+	w  io.Writer
+	mu sync.Mutex
+}
+
+// NewWriterExporter creates an exporter writing one JSON document per span
+// to w.
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{w: w}
+}
+
+// NewStdoutExporter creates an exporter writing JSON lines to the standard
+// output.
+func NewStdoutExporter() *WriterExporter {
+	return NewWriterExporter(os.Stdout)
+}
+
+// ExportSpan encodes span as a single JSON line.
+func (e *WriterExporter) ExportSpan(_ context.Context, span SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return json.NewEncoder(e.w).Encode(span)
+}
+
+// MemoryExporter keeps finished spans in memory, suitable for testing.
+type MemoryExporter struct {
+	spans []SpanData
+	mu    sync.Mutex
+}
+
+// NewMemoryExporter creates an empty MemoryExporter.
+func NewMemoryExporter() *MemoryExporter {
+	return &MemoryExporter{}
+}
+
+// ExportSpan appends span to the recorded spans.
+func (e *MemoryExporter) ExportSpan(_ context.Context, span SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, span)
+	return nil
+}
+
+// Spans returns a copy of the recorded spans in the order they ended.
+func (e *MemoryExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]SpanData(nil), e.spans...)
+}
+
+// Reset discards the recorded spans.
+func (e *MemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
@@ -0,0 +1,230 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/candango/httpok/logger"
+)
+
+// SpanKind describes the relationship between a span and its callers.
+type SpanKind string
+
+const (
+	SpanKindInternal SpanKind = "internal"
+	SpanKindServer   SpanKind = "server"
+	SpanKindClient   SpanKind = "client"
+)
+
+// StatusCode is the final status of a span.
+type StatusCode string
+
+const (
+	StatusUnset StatusCode = "unset"
+	StatusOK    StatusCode = "ok"
+	StatusError StatusCode = "error"
+)
+
+// SpanData is the immutable snapshot of a finished span handed to exporters.
+type SpanData struct {
+	Name          string         `json:"name"`
+	Kind          SpanKind       `json:"kind"`
+	TraceID       string         `json:"trace_id"`
+	SpanID        string         `json:"span_id"`
+	ParentSpanID  string         `json:"parent_span_id,omitempty"`
+	TraceState    string         `json:"trace_state,omitempty"`
+	Start         time.Time      `json:"start"`
+	End           time.Time      `json:"end"`
+	Duration      time.Duration  `json:"duration"`
+	Status        StatusCode     `json:"status"`
+	StatusMessage string         `json:"status_message,omitempty"`
+	Attributes    map[string]any `json:"attributes,omitempty"`
+}
+
+// Tracer starts spans and hands finished spans to its exporter.
+type Tracer struct {
+	Exporter Exporter
+	// Logger receives exporter errors. A nil logger uses the standard
+	// logger.
+	Logger logger.Logger
+}
+
+// NewTracer creates a Tracer exporting finished spans to exporter.
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{
+		Exporter: exporter,
+		Logger:   &logger.StandardLogger{},
+	}
+}
+
+// Start starts a span named name. The span is a child of the current span in
+// ctx, or of the remote span context stored in ctx; otherwise it starts a new
+// trace. The returned context carries the new span.
+func (t *Tracer) Start(ctx context.Context, name string,
+	kind SpanKind) (context.Context, *Span) {
+	span := &Span{
+		tracer: t,
+		name:   name,
+		kind:   kind,
+		start:  time.Now(),
+		status: StatusUnset,
+	}
+	if parent, ok := SpanContextFromContext(ctx); ok {
+		span.sc = SpanContext{
+			TraceID:    parent.TraceID,
+			SpanID:     newSpanID(),
+			Flags:      parent.Flags,
+			TraceState: parent.TraceState,
+		}
+		span.parent = parent.SpanID
+	} else {
+		span.sc = SpanContext{
+			TraceID: newTraceID(),
+			SpanID:  newSpanID(),
+			Flags:   FlagSampled,
+		}
+	}
+	return context.WithValue(ctx, ContextSpanValue, span), span
+}
+
+// SpanFromContext retrieves the current span from the context. It returns
+// nil when no span was started.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(ContextSpanValue).(*Span)
+	return span
+}
+
+// StartSpan starts a child of the current span in ctx using the tracer that
+// started it. When ctx carries no span, tracing is disabled for the call: ctx
+// is returned unchanged with a nil span, whose methods are no-ops.
+func StartSpan(ctx context.Context, name string,
+	kind SpanKind) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.Start(ctx, name, kind)
+}
+
+// Span records a timed operation. All methods are safe for concurrent use and
+// on a nil Span.
+type Span struct {
+	tracer        *Tracer
+	name          string
+	kind          SpanKind
+	sc            SpanContext
+	parent        SpanID
+	start         time.Time
+	end           time.Time
+	status        StatusCode
+	statusMessage string
+	attributes    map[string]any
+	ended         bool
+	mu            sync.Mutex
+}
+
+// SpanContext returns the propagated context of the span.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetName renames the span, for example once the matched route is known.
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.name = name
+}
+
+// SetAttribute records a key/value attribute on the span.
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attributes == nil {
+		s.attributes = map[string]any{}
+	}
+	s.attributes[key] = value
+}
+
+// SetStatus sets the final status of the span.
+func (s *Span) SetStatus(code StatusCode, message string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = code
+	s.statusMessage = message
+}
+
+// RecordError marks the span as failed with err. A nil error is ignored.
+func (s *Span) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	s.SetStatus(StatusError, err.Error())
+}
+
+// End finishes the span and exports it unless its trace is not sampled, as
+// when the incoming traceparent cleared the sampled flag. Only the first call
+// has effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	data := s.data()
+	s.mu.Unlock()
+
+	if s.tracer == nil || s.tracer.Exporter == nil || !s.sc.Sampled() {
+		return
+	}
+	if err := s.tracer.Exporter.ExportSpan(context.Background(), data); err != nil {
+		l := s.tracer.Logger
+		if l == nil {
+			l = &logger.StandardLogger{}
+		}
+		l.Errorf("failed to export span %s: %v", data.Name, err)
+	}
+}
+
+// data builds the exported snapshot. The caller must hold s.mu.
+func (s *Span) data() SpanData {
+	data := SpanData{
+		Name:          s.name,
+		Kind:          s.kind,
+		TraceID:       s.sc.TraceID.String(),
+		SpanID:        s.sc.SpanID.String(),
+		TraceState:    s.sc.TraceState,
+		Start:         s.start,
+		End:           s.end,
+		Duration:      s.end.Sub(s.start),
+		Status:        s.status,
+		StatusMessage: s.statusMessage,
+	}
+	if s.parent.IsValid() {
+		data.ParentSpanID = s.parent.String()
+	}
+	if len(s.attributes) != 0 {
+		data.Attributes = make(map[string]any, len(s.attributes))
+		for key, value := range s.attributes {
+			data.Attributes[key] = value
+		}
+	}
+	return data
+}
@@ -0,0 +1,227 @@
+// Package tracing provides a small W3C Trace Context implementation for
+// httpok: traceparent/tracestate propagation, spans and pluggable exporters.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+const (
+	// ContextSpanValue is the context key for storing the current Span.
+	ContextSpanValue = "HTTPOKTRACINGCTXSPANVALUE"
+	// ContextRemoteValue is the context key for storing a SpanContext
+	// extracted from an incoming request.
+	ContextRemoteValue = "HTTPOKTRACINGCTXREMOTEVALUE"
+	// TraceParentHeader is the W3C traceparent header name.
+	TraceParentHeader = "traceparent"
+	// TraceStateHeader is the W3C tracestate header name.
+	TraceStateHeader = "tracestate"
+	// FlagSampled is the sampled bit of the trace flags.
+	FlagSampled byte = 0x01
+	// maxTraceStateLength bounds the propagated tracestate value.
+	maxTraceStateLength = 512
+)
+
+// TraceID identifies a trace.
+type TraceID [16]byte
+
+// String returns the lowercase hexadecimal form of the trace ID.
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid reports whether the trace ID is not all zeros.
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+// SpanID identifies a span inside a trace.
+type SpanID [8]byte
+
+// String returns the lowercase hexadecimal form of the span ID.
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid reports whether the span ID is not all zeros.
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// SpanContext is the propagated part of a span.
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Flags      byte
+	TraceState string
+}
+
+// IsValid reports whether both the trace and span IDs are valid.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Sampled reports whether the sampled flag is set.
+func (sc SpanContext) Sampled() bool {
+	return sc.Flags&FlagSampled != 0
+}
+
+// TraceParent formats the span context as a version 00 traceparent value.
+func (sc SpanContext) TraceParent() string {
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" +
+		hex.EncodeToString([]byte{sc.Flags})
+}
+
+// ParseTraceParent parses a traceparent header value.
+//
+// Version 00 values must match the exact format. Higher versions are parsed
+// by their version 00 prefix as required by the specification. Version ff,
+// invalid hex and all-zero IDs are rejected.
+func ParseTraceParent(value string) (SpanContext, bool) {
+	var sc SpanContext
+	value = strings.TrimSpace(value)
+	if len(value) < 55 || value[2] != '-' || value[35] != '-' ||
+		value[52] != '-' {
+		return sc, false
+	}
+	version, ok := decodeLowerHex(value[:2])
+	if !ok || version[0] == 0xff {
+		return sc, false
+	}
+	if version[0] == 0 && len(value) != 55 {
+		return sc, false
+	}
+	if len(value) > 55 && value[55] != '-' {
+		return sc, false
+	}
+	traceID, ok := decodeLowerHex(value[3:35])
+	if !ok {
+		return sc, false
+	}
+	spanID, ok := decodeLowerHex(value[36:52])
+	if !ok {
+		return sc, false
+	}
+	flags, ok := decodeLowerHex(value[53:55])
+	if !ok {
+		return sc, false
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Flags = flags[0]
+	return sc, sc.IsValid()
+}
+
+func decodeLowerHex(value string) ([]byte, bool) {
+	for _, char := range value {
+		if (char < '0' || char > '9') && (char < 'a' || char > 'f') {
+			return nil, false
+		}
+	}
+	b, err := hex.DecodeString(value)
+	return b, err == nil
+}
+
+// Extract reads the traceparent and tracestate headers from h. The
+// tracestate is only kept when the traceparent is valid.
+func Extract(h http.Header) (SpanContext, bool) {
+	sc, ok := ParseTraceParent(h.Get(TraceParentHeader))
+	if !ok {
+		return SpanContext{}, false
+	}
+	state := strings.TrimSpace(strings.Join(h.Values(TraceStateHeader), ","))
+	if len(state) <= maxTraceStateLength {
+		sc.TraceState = state
+	}
+	return sc, true
+}
+
+// Inject writes the traceparent and tracestate headers for the current span
+// in ctx, falling back to a remote span context stored by the Tracing
+// middleware.
+func Inject(ctx context.Context, h http.Header) {
+	sc, ok := SpanContextFromContext(ctx)
+	if !ok {
+		return
+	}
+	h.Set(TraceParentHeader, sc.TraceParent())
+	if sc.TraceState != "" {
+		h.Set(TraceStateHeader, sc.TraceState)
+	} else {
+		h.Del(TraceStateHeader)
+	}
+}
+
+// ContextWithRemoteSpanContext returns a copy of ctx carrying a span context
+// received from a remote caller. Spans started from ctx become its children.
+func ContextWithRemoteSpanContext(ctx context.Context,
+	sc SpanContext) context.Context {
+	return context.WithValue(ctx, ContextRemoteValue, sc)
+}
+
+// SpanContextFromContext returns the span context of the current span in
+// ctx, or the remote span context when no local span was started.
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.SpanContext(), true
+	}
+	sc, ok := ctx.Value(ContextRemoteValue).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
+
+// Transport is an http.RoundTripper that records a client span for each
+// outgoing request and propagates the trace context to the upstream service.
+// Requests whose context has no current span are sent unchanged.
+type Transport struct {
+	// Base is the transport used to send the request. A nil Base uses
+	// http.DefaultTransport.
+	Base http.RoundTripper
+}
+
+// RoundTrip sends req as a child span of the span found in its context.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	ctx, span := StartSpan(req.Context(), "HTTP "+req.Method, SpanKindClient)
+	if span == nil {
+		return base.RoundTrip(req)
+	}
+	defer span.End()
+	span.SetAttribute("http.request.method", req.Method)
+	span.SetAttribute("url.full", req.URL.Redacted())
+	// A RoundTripper must not modify the caller's request.
+	req = req.Clone(ctx)
+	Inject(ctx, req.Header)
+	res, err := base.RoundTrip(req)
+	if err != nil {
+		span.RecordError(err)
+		return res, err
+	}
+	span.SetAttribute("http.response.status_code", res.StatusCode)
+	if res.StatusCode >= 500 {
+		span.SetStatus(StatusError, http.StatusText(res.StatusCode))
+	}
+	return res, err
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		_, _ = rand.Read(id[:])
+	}
+	return id
+}
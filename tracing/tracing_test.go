@@ -0,0 +1,129 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const validTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestParseTraceParent(t *testing.T) {
+	sc, ok := ParseTraceParent(validTraceParent)
+	assert.True(t, ok)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	assert.True(t, sc.Sampled())
+	assert.Equal(t, validTraceParent, sc.TraceParent())
+
+	_, ok = ParseTraceParent(
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future")
+	assert.True(t, ok)
+
+	for _, value := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00_4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		_, ok := ParseTraceParent(value)
+		assert.False(t, ok, value)
+	}
+}
+
+func TestExtractAndInject(t *testing.T) {
+	in := http.Header{}
+	in.Set(TraceParentHeader, validTraceParent)
+	in.Add(TraceStateHeader, "vendor=a")
+	in.Add(TraceStateHeader, "other=b")
+	sc, ok := Extract(in)
+	assert.True(t, ok)
+	assert.Equal(t, "vendor=a,other=b", sc.TraceState)
+
+	exporter := NewMemoryExporter()
+	tracer := NewTracer(exporter)
+	ctx := ContextWithRemoteSpanContext(context.Background(), sc)
+	ctx, span := tracer.Start(ctx, "child", SpanKindInternal)
+
+	out := http.Header{}
+	Inject(ctx, out)
+	child, ok := ParseTraceParent(out.Get(TraceParentHeader))
+	assert.True(t, ok)
+	assert.Equal(t, sc.TraceID, child.TraceID)
+	assert.Equal(t, span.SpanContext().SpanID, child.SpanID)
+	assert.Equal(t, "vendor=a,other=b", out.Get(TraceStateHeader))
+
+	span.End()
+	span.End()
+	spans := exporter.Spans()
+	if assert.Len(t, spans, 1) {
+		assert.Equal(t, "00f067aa0ba902b7", spans[0].ParentSpanID)
+		assert.Equal(t, StatusUnset, spans[0].Status)
+	}
+}
+
+func TestStartSpanWithoutParentIsNoop(t *testing.T) {
+	ctx, span := StartSpan(context.Background(), "orphan", SpanKindInternal)
+	assert.Nil(t, span)
+	assert.Nil(t, SpanFromContext(ctx))
+	span.SetAttribute("key", "value")
+	span.RecordError(errors.New("ignored"))
+	span.End()
+}
+
+func TestTransportRecordsClientSpan(t *testing.T) {
+	received := make(chan string, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			received <- r.Header.Get(TraceParentHeader)
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+	defer upstream.Close()
+
+	exporter := NewMemoryExporter()
+	ctx, root := NewTracer(exporter).Start(context.Background(), "root",
+		SpanKindServer)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, upstream.URL, nil)
+	assert.NoError(t, err)
+	res, err := (&http.Client{Transport: &Transport{}}).Do(req)
+	assert.NoError(t, err)
+	res.Body.Close()
+	root.End()
+
+	propagated, ok := ParseTraceParent(<-received)
+	assert.True(t, ok)
+	spans := exporter.Spans()
+	if assert.Len(t, spans, 2) {
+		client := spans[0]
+		assert.Equal(t, SpanKindClient, client.Kind)
+		assert.Equal(t, propagated.SpanID.String(), client.SpanID)
+		assert.Equal(t, spans[1].SpanID, client.ParentSpanID)
+		assert.Equal(t, StatusError, client.Status)
+		assert.Equal(t, http.StatusBadGateway,
+			client.Attributes["http.response.status_code"])
+	}
+	assert.Empty(t, req.Header.Get(TraceParentHeader))
+}
+
+func TestWriterExporter(t *testing.T) {
+	var buf bytes.Buffer
+	_, span := NewTracer(NewWriterExporter(&buf)).Start(context.Background(),
+		"op", SpanKindInternal)
+	span.SetAttribute("answer", 42)
+	span.End()
+
+	data := SpanData{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &data))
+	assert.Equal(t, "op", data.Name)
+	assert.Equal(t, float64(42), data.Attributes["answer"])
+	assert.Len(t, data.TraceID, 32)
+}